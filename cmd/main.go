@@ -10,7 +10,10 @@ import (
 	"github.com/folivorra/ziper/internal/adapter/archiver"
 	"github.com/folivorra/ziper/internal/adapter/downloader"
 	"github.com/folivorra/ziper/internal/config"
+	"github.com/folivorra/ziper/internal/httptransport"
+	"github.com/folivorra/ziper/internal/mimetype"
 	"github.com/folivorra/ziper/internal/model"
+	"github.com/folivorra/ziper/internal/netguard"
 	"github.com/folivorra/ziper/internal/repository"
 	"github.com/folivorra/ziper/internal/transport/rest"
 	"github.com/folivorra/ziper/internal/transport/validation"
@@ -40,21 +43,110 @@ func main() {
 	a := app.NewApp(logger)
 	defer a.Shutdown()
 
-	z := archiver.NewZipArchiver(a, cfg.ArchDir, logger)
-	d := downloader.NewHTTPDownloader(a, cfg.DownloadDir, logger, cfg.Timeout)
-	v := validation.NewHTTPValidator(cfg.Timeout)
+	keepArchives := cfg.IsPersistent()
+	archivers := map[model.ArchiveFormat]archiver.Archiver{
+		model.ArchiveFormatZip: archiver.NewZipArchiver(
+			a,
+			cfg.ArchDir,
+			logger,
+			keepArchives,
+			archiver.Compression(cfg.Compression),
+			cfg.CompressLevel,
+		),
+		model.ArchiveFormatTarGz:  archiver.NewTarGzArchiver(a, cfg.ArchDir, logger, keepArchives, cfg.CompressLevel),
+		model.ArchiveFormatTarZst: archiver.NewTarZstArchiver(a, cfg.ArchDir, logger, keepArchives, cfg.ZstdLevel),
+	}
+	allowedTypes := mimetype.NewAllowList(cfg.AllowedTypes)
+
+	var guard *netguard.Guard
+	if cfg.SSRFProtection {
+		guard, err = netguard.NewGuard(cfg.BlockedCIDRs, cfg.AllowedHosts, cfg.DeniedHosts)
+		if err != nil {
+			logger.Error("failed to configure ssrf guard", slog.String("error", err.Error()))
+			return
+		}
+	}
+	transport := httptransport.New(httptransport.Config{
+		DialTimeout:           cfg.DialTimeout,
+		KeepAlive:             cfg.KeepAlive,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+	}, guard)
+
+	var cache *downloader.Cache
+	if cfg.CacheEnabled {
+		cache, err = downloader.NewCache(cfg.CacheDir, cfg.CacheTTL, cfg.CacheMaxSize, logger)
+		if err != nil {
+			logger.Error("failed to open download cache",
+				slog.String("path", cfg.CacheDir),
+				slog.String("error", err.Error()),
+			)
+			return
+		}
+	}
+
+	d := downloader.NewHTTPDownloader(
+		a,
+		cfg.DownloadDir,
+		logger,
+		cfg.DownloadTimeout,
+		cfg.IdleReadTimeout,
+		transport,
+		cfg.IsPersistent() && cfg.IsStreamingArchives(),
+		allowedTypes,
+		downloader.RetryPolicy{
+			MaxAttempts: cfg.RetryAttempts,
+			BaseDelay:   cfg.RetryBaseDelay,
+			MaxDelay:    cfg.RetryMaxDelay,
+		},
+		cache,
+	)
+	v := validation.NewHTTPValidator(cfg.Timeout, transport, allowedTypes, cfg.MaxFileSize)
 
 	l := usecase.NewLockTaskManager()
 
-	repo := repository.NewInMemoryTaskRepo()
+	var repo repository.TaskRepo
+	switch cfg.RepoType {
+	case config.RepoTypeFile:
+		fileRepo, err := repository.NewFileTaskRepo(cfg.RepoPath)
+		if err != nil {
+			logger.Error("failed to open task repo",
+				slog.String("path", cfg.RepoPath),
+				slog.String("error", err.Error()),
+			)
+			return
+		}
+		a.RegisterCleanup(func(ctx context.Context) {
+			if err := fileRepo.Close(); err != nil {
+				logger.Warn("failed to close task repo", slog.String("error", err.Error()))
+			}
+		})
+		repo = fileRepo
+	default:
+		repo = repository.NewInMemoryTaskRepo()
+	}
+
+	taskQueue := usecase.NewPriorityQueue()
 
-	taskQueue := make(chan *model.Task, cfg.MaxTasks)
+	sched := usecase.NewDownloadScheduler(cfg.HostMaxDownloads, cfg.HostRateLimit, cfg.GlobalRateLimit)
 
-	ts := usecase.NewTaskService(repo, cfg, logger, l, v, d, z, taskQueue)
+	ts := usecase.NewTaskService(repo, cfg, logger, l, v, d, archivers, sched, taskQueue)
+
+	// restore before the workers start, so they only see restored tasks
+	if err := ts.Restore(); err != nil {
+		logger.Error("failed to restore tasks", slog.String("error", err.Error()))
+		return
+	}
 
 	wp := usecase.NewWorkerPool(ctx, a, cfg.WorkersNum, ts, logger, taskQueue)
 	wp.Start()
 
+	usecase.NewJanitor(a, ts, cfg.JanitorInterval, logger).Start()
+
 	srv := rest.NewServer(a, ts, logger, cfg.Port)
 
 	go func() {
@@ -3,8 +3,9 @@ module github.com/folivorra/ziper
 go 1.24.2
 
 require (
-	github.com/caarlos0/env v3.5.0+incompatible // indirect
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
+	github.com/caarlos0/env v3.5.0+incompatible
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/mux v1.8.1
+	github.com/joho/godotenv v1.5.1
+	github.com/klauspost/compress v1.18.0
 )
@@ -0,0 +1,267 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/folivorra/ziper/app"
+	"github.com/klauspost/compress/zstd"
+)
+
+var testFiles = map[string]string{
+	"a.pdf": "%PDF-1.4 first",
+	"b.pdf": "%PDF-1.4 second",
+}
+
+// newTaskDir lays out a task directory with two downloaded files, a file
+// the manifest doesn't put into the archive and a leftover it doesn't know.
+func newTaskDir(t *testing.T) (string, *Manifest) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "task-1")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	files := map[string]string{"c.pdf": "not archived", "leftover.part": "partial"}
+	for name, content := range testFiles {
+		files[name] = content
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	manifest := &Manifest{
+		TaskID:    1,
+		CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Files: []ManifestEntry{
+			{URL: "http://example.com/a.pdf", Name: "a.pdf", Status: "completed", Archived: true},
+			{URL: "http://example.com/c.pdf", Name: "c.pdf", Status: "checksum_mismatch"},
+			{URL: "http://example.com/b.pdf", Name: "b.pdf", Status: "completed", Archived: true},
+		},
+	}
+	return dir, manifest
+}
+
+func newTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// checkArchiveDir makes sure only the final archive is left in dir.
+func checkArchiveDir(t *testing.T, dir, name string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != name {
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Fatalf("archive directory holds %v, want only %s", names, name)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("archive mode = %v, want 0644", info.Mode().Perm())
+	}
+}
+
+// checkEntries compares the names and contents read back from an archive;
+// the manifest must come first and list every file, archived or not.
+func checkEntries(t *testing.T, names []string, contents map[string]string) {
+	t.Helper()
+	want := []string{ManifestName, "a.pdf", "b.pdf"}
+	if len(names) != len(want) {
+		t.Fatalf("archive entries = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("archive entries = %v, want %v", names, want)
+		}
+	}
+
+	for name, content := range testFiles {
+		if contents[name] != content {
+			t.Errorf("%s = %q, want %q", name, contents[name], content)
+		}
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal([]byte(contents[ManifestName]), &manifest); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	if manifest.TaskID != 1 || len(manifest.Files) != 3 {
+		t.Errorf("manifest = %+v, want task 1 with 3 files", manifest)
+	}
+}
+
+func TestZipArchiverRoundTrip(t *testing.T) {
+	tests := []struct {
+		compression Compression
+		method      uint16
+	}{
+		{CompressionStore, zip.Store},
+		{CompressionDeflate, zip.Deflate},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.compression), func(t *testing.T) {
+			taskDir, manifest := newTaskDir(t)
+			outDir := filepath.Join(t.TempDir(), "archives")
+			logger := newTestLogger()
+			a := NewZipArchiver(app.NewApp(logger), outDir, logger, true, tt.compression, -1)
+
+			if err := a.ArchiveDirectory(context.Background(), taskDir, manifest); err != nil {
+				t.Fatalf("ArchiveDirectory: %v", err)
+			}
+			checkArchiveDir(t, outDir, "task-1.zip")
+
+			r, err := zip.OpenReader(filepath.Join(outDir, "task-1.zip"))
+			if err != nil {
+				t.Fatalf("OpenReader: %v", err)
+			}
+			defer r.Close()
+
+			names := make([]string, 0, len(r.File))
+			contents := make(map[string]string)
+			for _, f := range r.File {
+				names = append(names, f.Name)
+				if f.Name != ManifestName && f.Method != tt.method {
+					t.Errorf("%s method = %d, want %d", f.Name, f.Method, tt.method)
+				}
+
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatalf("open %s: %v", f.Name, err)
+				}
+				data, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					t.Fatalf("read %s: %v", f.Name, err)
+				}
+				contents[f.Name] = string(data)
+			}
+			checkEntries(t, names, contents)
+		})
+	}
+}
+
+func TestTarArchiverRoundTrip(t *testing.T) {
+	tests := []struct {
+		name          string
+		newArchiver   func(a *app.App, dir string, logger *slog.Logger) *TarArchiver
+		file          string
+		newDecompress func(r io.Reader) (io.Reader, error)
+	}{
+		{
+			name: "tar.gz",
+			newArchiver: func(a *app.App, dir string, logger *slog.Logger) *TarArchiver {
+				return NewTarGzArchiver(a, dir, logger, true, -1)
+			},
+			file: "task-1.tar.gz",
+			newDecompress: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+		},
+		{
+			name: "tar.zst",
+			newArchiver: func(a *app.App, dir string, logger *slog.Logger) *TarArchiver {
+				return NewTarZstArchiver(a, dir, logger, true, 0)
+			},
+			file: "task-1.tar.zst",
+			newDecompress: func(r io.Reader) (io.Reader, error) {
+				return zstd.NewReader(r)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskDir, manifest := newTaskDir(t)
+			outDir := filepath.Join(t.TempDir(), "archives")
+			logger := newTestLogger()
+			a := tt.newArchiver(app.NewApp(logger), outDir, logger)
+
+			if err := a.ArchiveDirectory(context.Background(), taskDir, manifest); err != nil {
+				t.Fatalf("ArchiveDirectory: %v", err)
+			}
+			checkArchiveDir(t, outDir, tt.file)
+
+			f, err := os.Open(filepath.Join(outDir, tt.file))
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer f.Close()
+
+			decompressed, err := tt.newDecompress(f)
+			if err != nil {
+				t.Fatalf("decompress: %v", err)
+			}
+
+			tr := tar.NewReader(decompressed)
+			names := make([]string, 0, 3)
+			contents := make(map[string]string)
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Next: %v", err)
+				}
+				if header.Uid != 0 || header.Gid != 0 || header.Uname != "" || header.Gname != "" || header.Mode != 0644 {
+					t.Errorf("%s header = uid %d gid %d %q %q mode %o, want no owner and 0644",
+						header.Name, header.Uid, header.Gid, header.Uname, header.Gname, header.Mode)
+				}
+
+				data, err := io.ReadAll(tr)
+				if err != nil {
+					t.Fatalf("read %s: %v", header.Name, err)
+				}
+				names = append(names, header.Name)
+				contents[header.Name] = string(data)
+			}
+			checkEntries(t, names, contents)
+		})
+	}
+}
+
+func TestArchiveDirectoryKeepsOldArchiveOnError(t *testing.T) {
+	taskDir, manifest := newTaskDir(t)
+	outDir := filepath.Join(t.TempDir(), "archives")
+	logger := newTestLogger()
+	a := NewZipArchiver(app.NewApp(logger), outDir, logger, true, CompressionDeflate, -1)
+
+	if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outDir, "task-1.zip"), []byte("old"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// an archived file that is gone fails the archive halfway through
+	manifest.Files = append(manifest.Files, ManifestEntry{Name: "gone.pdf", Status: "completed", Archived: true})
+	if err := a.ArchiveDirectory(context.Background(), taskDir, manifest); err == nil {
+		t.Fatal("ArchiveDirectory succeeded with a missing file")
+	}
+
+	checkArchiveDir(t, outDir, "task-1.zip")
+	if data, _ := os.ReadFile(filepath.Join(outDir, "task-1.zip")); string(data) != "old" {
+		t.Errorf("archive = %q after a failed run, want the old one kept", data)
+	}
+}
@@ -0,0 +1,55 @@
+package archiver
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// writeAtomically streams an archive into a temp file next to the final one
+// and renames it into place, so a half-written archive is never served.
+func writeAtomically(dir, name string, logger *slog.Logger, write func(io.Writer) error) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+name+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp archive: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		removeTemp(tmpPath, logger)
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		removeTemp(tmpPath, logger)
+		return fmt.Errorf("failed to close temp archive: %w", err)
+	}
+
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		removeTemp(tmpPath, logger)
+		return fmt.Errorf("failed to set archive permissions: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, filepath.Join(dir, name)); err != nil {
+		removeTemp(tmpPath, logger)
+		return fmt.Errorf("failed to move archive into place: %w", err)
+	}
+
+	return nil
+}
+
+func removeTemp(path string, logger *slog.Logger) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logger.Warn("failed to remove temp archive",
+			slog.String("path", path),
+			slog.String("error", err.Error()),
+		)
+	}
+}
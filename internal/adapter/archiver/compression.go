@@ -0,0 +1,17 @@
+package archiver
+
+import "archive/zip"
+
+type Compression string
+
+const (
+	CompressionStore   Compression = "store"
+	CompressionDeflate Compression = "deflate"
+)
+
+func (c Compression) method() uint16 {
+	if c == CompressionStore {
+		return zip.Store
+	}
+	return zip.Deflate
+}
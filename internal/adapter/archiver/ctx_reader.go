@@ -0,0 +1,19 @@
+package archiver
+
+import (
+	"context"
+	"io"
+)
+
+// ctxReader stops a long io.Copy as soon as the archiving is cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -1,5 +1,13 @@
 package archiver
 
+import (
+	"context"
+	"io"
+)
+
 type Archiver interface {
-	ArchiveDirectory(dirPath string) error
+	ArchiveDirectory(ctx context.Context, dirPath string, manifest *Manifest) error
+	WriteArchive(ctx context.Context, w io.Writer, dirPath string, manifest *Manifest) error
+	Extension() string
+	ContentType() string
 }
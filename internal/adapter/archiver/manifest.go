@@ -0,0 +1,67 @@
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const ManifestName = "manifest.json"
+
+// Manifest lists every file of the task, including the ones that didn't
+// make it into the archive, so the archive can be checked on its own.
+type Manifest struct {
+	TaskID    uint64          `json:"task_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Files     []ManifestEntry `json:"files"`
+}
+
+type ManifestEntry struct {
+	URL    string `json:"url"`
+	Name   string `json:"name,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Archived entries are the files put into the archive under Name.
+	Archived bool `json:"-"`
+}
+
+func (m *Manifest) encode() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// eachArchived opens the archived files of dirPath in manifest order, so
+// leftovers in the directory never get into the archive.
+func (m *Manifest) eachArchived(ctx context.Context, dirPath string, fn func(f *os.File, info os.FileInfo) error) error {
+	for _, entry := range m.Files {
+		if !entry.Archived {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := openArchived(filepath.Join(dirPath, entry.Name), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func openArchived(path string, fn func(f *os.File, info os.FileInfo) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return fn(file, info)
+}
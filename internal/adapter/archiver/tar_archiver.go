@@ -0,0 +1,175 @@
+package archiver
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/folivorra/ziper/app"
+	"github.com/klauspost/compress/zstd"
+)
+
+type TarArchiver struct {
+	a             *app.App
+	tarDir        string
+	logger        *slog.Logger
+	extension     string
+	contentType   string
+	newCompressor func(w io.Writer) (io.WriteCloser, error)
+}
+
+var _ Archiver = (*TarArchiver)(nil)
+
+func NewTarGzArchiver(a *app.App, tarDir string, logger *slog.Logger, keepOnShutdown bool, level int) *TarArchiver {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		logger.Warn("invalid archive compression level, using default",
+			slog.Int("level", level),
+		)
+		level = gzip.DefaultCompression
+	}
+
+	return newTarArchiver(a, tarDir, logger, keepOnShutdown, ".tar.gz", "application/gzip",
+		func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+	)
+}
+
+// NewTarZstArchiver takes a zstd level from 1 to 22, 0 means the default;
+// the levels are mapped onto the four speeds of the encoder.
+func NewTarZstArchiver(a *app.App, tarDir string, logger *slog.Logger, keepOnShutdown bool, level int) *TarArchiver {
+	if level < 0 || level > 22 {
+		logger.Warn("invalid zstd compression level, using default",
+			slog.Int("level", level),
+		)
+		level = 0
+	}
+
+	encoderLevel := zstd.SpeedDefault
+	if level > 0 {
+		encoderLevel = zstd.EncoderLevelFromZstd(level)
+	}
+
+	return newTarArchiver(a, tarDir, logger, keepOnShutdown, ".tar.zst", "application/zstd",
+		func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderLevel(encoderLevel))
+		},
+	)
+}
+
+func newTarArchiver(
+	a *app.App,
+	tarDir string,
+	logger *slog.Logger,
+	keepOnShutdown bool,
+	extension string,
+	contentType string,
+	newCompressor func(w io.Writer) (io.WriteCloser, error),
+) *TarArchiver {
+	ta := &TarArchiver{
+		a:             a,
+		tarDir:        tarDir,
+		logger:        logger,
+		extension:     extension,
+		contentType:   contentType,
+		newCompressor: newCompressor,
+	}
+
+	if keepOnShutdown {
+		return ta
+	}
+
+	ta.a.RegisterCleanup(func(ctx context.Context) {
+		if err := os.RemoveAll(ta.tarDir); err != nil {
+			ta.logger.Warn("failed to remove archives directory")
+			return
+		}
+		ta.logger.Info("removed archives directory")
+	})
+
+	return ta
+}
+
+func (a *TarArchiver) ArchiveDirectory(ctx context.Context, dirPath string, manifest *Manifest) error {
+	return writeAtomically(a.tarDir, filepath.Base(dirPath)+a.extension, a.logger, func(w io.Writer) error {
+		return a.WriteArchive(ctx, w, dirPath, manifest)
+	})
+}
+
+func (a *TarArchiver) WriteArchive(ctx context.Context, out io.Writer, dirPath string, manifest *Manifest) error {
+	compressor, err := a.newCompressor(out)
+	if err != nil {
+		return err
+	}
+	tarWriter := tar.NewWriter(compressor)
+
+	if err := a.writeManifest(tarWriter, manifest); err != nil {
+		tarWriter.Close()
+		compressor.Close()
+		return err
+	}
+
+	err = manifest.eachArchived(ctx, dirPath, func(file *os.File, info os.FileInfo) error {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = info.Name()
+		// the owner and mode of the service's files mean nothing to the client
+		header.Uid, header.Gid = 0, 0
+		header.Uname, header.Gname = "", ""
+		header.Mode = 0644
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		_, err = io.Copy(tarWriter, &ctxReader{ctx: ctx, r: file})
+		return err
+	})
+
+	if err != nil {
+		tarWriter.Close()
+		compressor.Close()
+		return err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		compressor.Close()
+		return err
+	}
+
+	return compressor.Close()
+}
+
+func (a *TarArchiver) writeManifest(tarWriter *tar.Writer, manifest *Manifest) error {
+	data, err := manifest.encode()
+	if err != nil {
+		return err
+	}
+
+	err = tarWriter.WriteHeader(&tar.Header{
+		Name:    ManifestName,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: manifest.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tarWriter.Write(data)
+	return err
+}
+
+func (a *TarArchiver) Extension() string {
+	return a.extension
+}
+
+func (a *TarArchiver) ContentType() string {
+	return a.contentType
+}
@@ -2,9 +2,8 @@ package archiver
 
 import (
 	"archive/zip"
-	"bytes"
+	"compress/flate"
 	"context"
-	"fmt"
 	"io"
 	"log/slog"
 	"os"
@@ -14,18 +13,47 @@ import (
 )
 
 type ZipArchiver struct {
-	a      *app.App
-	zipDir string
-	logger *slog.Logger
+	a           *app.App
+	zipDir      string
+	logger      *slog.Logger
+	compression Compression
+	level       int
 }
 
 var _ Archiver = (*ZipArchiver)(nil)
 
-func NewZipArchiver(a *app.App, zipDir string, logger *slog.Logger) *ZipArchiver {
+func NewZipArchiver(
+	a *app.App,
+	zipDir string,
+	logger *slog.Logger,
+	keepOnShutdown bool,
+	compression Compression,
+	level int,
+) *ZipArchiver {
+	if compression != CompressionStore && compression != CompressionDeflate {
+		logger.Warn("unknown archive compression, using deflate",
+			slog.String("compression", string(compression)),
+		)
+		compression = CompressionDeflate
+	}
+
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		logger.Warn("invalid archive compression level, using default",
+			slog.Int("level", level),
+		)
+		level = flate.DefaultCompression
+	}
+
 	za := &ZipArchiver{
-		a:      a,
-		zipDir: zipDir,
-		logger: logger,
+		a:           a,
+		zipDir:      zipDir,
+		logger:      logger,
+		compression: compression,
+		level:       level,
+	}
+
+	if keepOnShutdown {
+		return za
 	}
 
 	za.a.RegisterCleanup(func(ctx context.Context) {
@@ -39,33 +67,48 @@ func NewZipArchiver(a *app.App, zipDir string, logger *slog.Logger) *ZipArchiver
 	return za
 }
 
-func (a *ZipArchiver) ArchiveDirectory(dirPath string) error {
-	var buf bytes.Buffer
-	zipWriter := zip.NewWriter(&buf)
+func (a *ZipArchiver) ArchiveDirectory(ctx context.Context, dirPath string, manifest *Manifest) error {
+	return writeAtomically(a.zipDir, filepath.Base(dirPath)+a.Extension(), a.logger, func(w io.Writer) error {
+		return a.WriteArchive(ctx, w, dirPath, manifest)
+	})
+}
 
-	err := filepath.Walk(dirPath, func(filePath string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+func (a *ZipArchiver) Extension() string {
+	return ".zip"
+}
 
-		if info.IsDir() {
-			return nil
-		}
+func (a *ZipArchiver) ContentType() string {
+	return "application/zip"
+}
 
-		file, err := os.Open(filePath)
+// WriteArchive streams a zip of the archived manifest files from dirPath
+// into w without touching the disk, which lets the archive be served
+// straight to an HTTP response. The manifest goes first.
+func (a *ZipArchiver) WriteArchive(ctx context.Context, out io.Writer, dirPath string, manifest *Manifest) error {
+	zipWriter := zip.NewWriter(out)
+	zipWriter.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, a.level)
+	})
+
+	if err := a.writeManifest(zipWriter, manifest); err != nil {
+		zipWriter.Close()
+		return err
+	}
+
+	err := manifest.eachArchived(ctx, dirPath, func(file *os.File, info os.FileInfo) error {
+		header, err := zip.FileInfoHeader(info)
 		if err != nil {
 			return err
 		}
-		defer file.Close()
-
-		relPath := info.Name()
+		header.Name = info.Name()
+		header.Method = a.compression.method()
 
-		writer, err := zipWriter.Create(relPath)
+		writer, err := zipWriter.CreateHeader(header)
 		if err != nil {
 			return err
 		}
 
-		_, err = io.Copy(writer, file)
+		_, err = io.Copy(writer, &ctxReader{ctx: ctx, r: file})
 		return err
 	})
 
@@ -74,21 +117,24 @@ func (a *ZipArchiver) ArchiveDirectory(dirPath string) error {
 		return err
 	}
 
-	if err := zipWriter.Close(); err != nil {
-		return err
-	}
-
-	zipName := filepath.Base(dirPath) + ".zip"
-	zipPath := filepath.Join(a.zipDir, zipName)
+	return zipWriter.Close()
+}
 
-	if err := os.MkdirAll(a.zipDir, os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create archive directory: %w", err)
+func (a *ZipArchiver) writeManifest(zipWriter *zip.Writer, manifest *Manifest) error {
+	data, err := manifest.encode()
+	if err != nil {
+		return err
 	}
 
-	err = os.WriteFile(zipPath, buf.Bytes(), 0644)
+	writer, err := zipWriter.CreateHeader(&zip.FileHeader{
+		Name:     ManifestName,
+		Method:   zip.Deflate,
+		Modified: manifest.CreatedAt,
+	})
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = writer.Write(data)
+	return err
 }
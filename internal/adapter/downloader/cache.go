@@ -0,0 +1,397 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const cacheIndexName = "index.json"
+
+// errCacheGone means the cached copy couldn't be used after all, so the
+// file has to be downloaded again.
+var errCacheGone = errors.New("cached copy is gone")
+
+// CacheEntry describes the last copy of a URL. The content itself is
+// stored once per SHA-256, so different URLs with equal bodies share it.
+type CacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	SHA256       string    `json:"sha256"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type"`
+	FileName     string    `json:"file_name,omitempty"`
+	StoredAt     time.Time `json:"stored_at"`
+	LastUsed     time.Time `json:"last_used"`
+}
+
+// Cache keeps downloaded files between tasks. Entries younger than ttl are
+// used as is, older ones are revalidated with a conditional GET. When the
+// content outgrows maxSize the least recently used entries are evicted.
+//
+// A nil *Cache is valid and caches nothing.
+type Cache struct {
+	mu      sync.Mutex
+	dir     string
+	ttl     time.Duration
+	maxSize int64
+	logger  *slog.Logger
+	entries map[string]*CacheEntry
+	blobs   map[string]int
+	// pins counts the copies being read out by Materialize; the content of
+	// a removed entry stays on disk until they are done
+	pins map[string]int
+	size int64
+}
+
+func NewCache(dir string, ttl time.Duration, maxSize int64, logger *slog.Logger) (*Cache, error) {
+	c := &Cache{
+		dir:     dir,
+		ttl:     ttl,
+		maxSize: maxSize,
+		logger:  logger,
+		entries: make(map[string]*CacheEntry),
+		blobs:   make(map[string]int),
+		pins:    make(map[string]int),
+	}
+
+	if err := os.MkdirAll(c.blobDir(), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, cacheIndexName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read cache index: %w", err)
+	}
+	if len(data) > 0 {
+		var entries []*CacheEntry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("failed to decode cache index: %w", err)
+		}
+		for _, entry := range entries {
+			if _, err := os.Stat(c.blobPath(entry.SHA256)); err != nil {
+				continue
+			}
+			c.add(entry)
+		}
+	}
+
+	c.removeOrphans()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict()
+
+	return c, c.flush()
+}
+
+// Lookup returns the cached entry for url; fresh reports whether it can be
+// used without asking the server.
+func (c *Cache) Lookup(url string) (entry CacheEntry, fresh, ok bool) {
+	if c == nil {
+		return CacheEntry{}, false, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[url]
+	if !ok {
+		return CacheEntry{}, false, false
+	}
+
+	return *e, time.Since(e.StoredAt) < c.ttl, true
+}
+
+// Materialize places the cached content at dst, hard-linking it when the
+// file system allows and copying otherwise. The content is pinned under the
+// lock and copied without it, so a large copy doesn't hold up the cache.
+func (c *Cache) Materialize(entry CacheEntry, dst string) error {
+	c.mu.Lock()
+	current, ok := c.entries[entry.URL]
+	if !ok || current.SHA256 != entry.SHA256 {
+		c.mu.Unlock()
+		return fmt.Errorf("cache entry for %s was evicted", entry.URL)
+	}
+	c.pins[entry.SHA256]++
+	c.mu.Unlock()
+
+	err := linkOrCopy(c.blobPath(entry.SHA256), dst)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.unpin(entry.SHA256)
+	if err != nil {
+		if current, ok := c.entries[entry.URL]; ok && current.SHA256 == entry.SHA256 {
+			c.remove(entry.URL)
+			c.flushOrWarn()
+		}
+		return fmt.Errorf("failed to read cached file: %w", err)
+	}
+
+	current.LastUsed = time.Now()
+
+	return nil
+}
+
+// Revalidated marks the entry fresh again after the server answered
+// 304 Not Modified.
+func (c *Cache) Revalidated(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[url]; ok {
+		e.StoredAt = time.Now()
+		c.flushOrWarn()
+	}
+}
+
+// Store adds a completed download with the given SHA-256 to the cache,
+// replacing the previous copy of the URL.
+func (c *Cache) Store(url, path, sum string, size int64, etag, lastModified, contentType, fileName string) error {
+	if c == nil {
+		return nil
+	}
+
+	if c.maxSize > 0 && size > c.maxSize {
+		return nil
+	}
+
+	// the content is linked or copied aside without the lock, so a large
+	// copy doesn't hold up other downloads; only publishing is locked
+	blob := c.blobPath(sum)
+	tmp := ""
+	if _, err := os.Stat(blob); errors.Is(err, os.ErrNotExist) {
+		tmp = fmt.Sprintf("%s.%s.tmp", blob, uuid.NewString())
+		if err := linkOrCopy(path, tmp); err != nil {
+			return fmt.Errorf("failed to store file in cache: %w", err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if tmp != "" {
+		if c.blobs[sum] > 0 {
+			// stored by another download meanwhile
+			os.Remove(tmp)
+		} else if err := os.Rename(tmp, blob); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to store file in cache: %w", err)
+		}
+	} else if _, err := os.Stat(blob); err != nil {
+		// evicted meanwhile, the next download stores it again
+		return nil
+	}
+
+	now := time.Now()
+	c.remove(url)
+	c.add(&CacheEntry{
+		URL:          url,
+		ETag:         etag,
+		LastModified: lastModified,
+		SHA256:       sum,
+		Size:         size,
+		ContentType:  contentType,
+		FileName:     fileName,
+		StoredAt:     now,
+		LastUsed:     now,
+	})
+	c.evict()
+
+	return c.flush()
+}
+
+func (c *Cache) add(entry *CacheEntry) {
+	c.entries[entry.URL] = entry
+	if c.blobs[entry.SHA256] == 0 {
+		c.size += entry.Size
+	}
+	c.blobs[entry.SHA256]++
+}
+
+// unpin releases a copy taken by Materialize and deletes the content if
+// its entries were removed meanwhile.
+func (c *Cache) unpin(sum string) {
+	c.pins[sum]--
+	if c.pins[sum] > 0 {
+		return
+	}
+	delete(c.pins, sum)
+
+	if c.blobs[sum] == 0 {
+		c.removeBlob(sum)
+	}
+}
+
+// remove drops the entry and deletes its content once no other URL
+// refers to it and no copy is being read out.
+func (c *Cache) remove(url string) {
+	entry, ok := c.entries[url]
+	if !ok {
+		return
+	}
+	delete(c.entries, url)
+
+	c.blobs[entry.SHA256]--
+	if c.blobs[entry.SHA256] > 0 {
+		return
+	}
+	delete(c.blobs, entry.SHA256)
+	c.size -= entry.Size
+
+	if c.pins[entry.SHA256] == 0 {
+		c.removeBlob(entry.SHA256)
+	}
+}
+
+func (c *Cache) removeBlob(sum string) {
+	if err := os.Remove(c.blobPath(sum)); err != nil && !os.IsNotExist(err) {
+		c.logger.Warn("failed to remove cached file",
+			slog.String("sha256", sum),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+func (c *Cache) evict() {
+	if c.maxSize <= 0 || c.size <= c.maxSize {
+		return
+	}
+
+	entries := make([]*CacheEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.Before(entries[j].LastUsed)
+	})
+
+	for _, e := range entries {
+		if c.size <= c.maxSize {
+			return
+		}
+		c.remove(e.URL)
+	}
+}
+
+// removeOrphans deletes content left without an index entry, e.g. after a
+// crash between storing a file and writing the index.
+func (c *Cache) removeOrphans() {
+	names, err := os.ReadDir(c.blobDir())
+	if err != nil {
+		return
+	}
+
+	for _, name := range names {
+		if _, ok := c.blobs[name.Name()]; ok {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.blobDir(), name.Name())); err != nil {
+			c.logger.Warn("failed to remove orphaned cache file",
+				slog.String("name", name.Name()),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+}
+
+func (c *Cache) flush() error {
+	entries := make([]*CacheEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache index: %w", err)
+	}
+
+	path := filepath.Join(c.dir, cacheIndexName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cache index: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace cache index: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Cache) flushOrWarn() {
+	if err := c.flush(); err != nil {
+		c.logger.Warn("failed to save cache index", slog.String("error", err.Error()))
+	}
+}
+
+func (c *Cache) blobDir() string {
+	return filepath.Join(c.dir, "sha256")
+}
+
+func (c *Cache) blobPath(sum string) string {
+	return filepath.Join(c.blobDir(), sum)
+}
+
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open file for hashing: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// linkOrCopy hard-links when it can; that is safe because neither the
+// cache nor the downloads directory writes to a file once it is complete.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, dst)
+}
@@ -0,0 +1,66 @@
+package downloader
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheKeepsPinnedContent(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewCache(filepath.Join(dir, "cache"), time.Hour, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+
+	src := filepath.Join(dir, "a.pdf")
+	if err := os.WriteFile(src, []byte("%PDF-1.4"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	sum, size, err := hashFile(src)
+	if err != nil {
+		t.Fatalf("hashFile: %v", err)
+	}
+	const url = "http://example.com/a.pdf"
+	if err := cache.Store(url, src, sum, size, "", "", "application/pdf", ""); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	entry, _, ok := cache.Lookup(url)
+	if !ok {
+		t.Fatal("Lookup found nothing after Store")
+	}
+	dst := filepath.Join(dir, "copy.pdf")
+	if err := cache.Materialize(entry, dst); err != nil {
+		t.Fatalf("Materialize: %v", err)
+	}
+	if data, err := os.ReadFile(dst); err != nil || string(data) != "%PDF-1.4" {
+		t.Errorf("materialized file = %q, %v", data, err)
+	}
+
+	// an entry removed while its content is read out keeps the content
+	// until the reader is done
+	blob := cache.blobPath(sum)
+	cache.mu.Lock()
+	cache.pins[sum]++
+	cache.remove(url)
+	cache.mu.Unlock()
+
+	if _, err := os.Stat(blob); err != nil {
+		t.Fatalf("pinned content was deleted: %v", err)
+	}
+
+	cache.mu.Lock()
+	cache.unpin(sum)
+	cache.mu.Unlock()
+
+	if _, err := os.Stat(blob); !os.IsNotExist(err) {
+		t.Errorf("content of a removed entry survived unpin: %v", err)
+	}
+	if err := cache.Materialize(entry, filepath.Join(dir, "again.pdf")); err == nil {
+		t.Error("Materialize of a removed entry succeeded")
+	}
+}
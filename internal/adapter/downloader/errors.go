@@ -0,0 +1,8 @@
+package downloader
+
+import "errors"
+
+var (
+	ErrNotSupportedType = errors.New("not supported file type")
+	ErrTooLarge         = errors.New("file too large")
+)
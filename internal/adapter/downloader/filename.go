@@ -0,0 +1,34 @@
+package downloader
+
+import (
+	"mime"
+	urler "net/url"
+	"path"
+)
+
+// fileNameFromDisposition returns the filename parameter of a
+// Content-Disposition header; RFC 5987 filename* values are decoded by
+// mime.ParseMediaType. The name is untrusted and must be sanitised.
+func fileNameFromDisposition(header string) string {
+	if header == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+// fileNameFromURL returns the last segment of the decoded URL path.
+func fileNameFromURL(url string) string {
+	parsed, err := urler.Parse(url)
+	if err != nil {
+		return ""
+	}
+	name := path.Base(parsed.Path)
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
@@ -1,7 +1,9 @@
 package downloader
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -12,26 +14,51 @@ import (
 	"time"
 
 	"github.com/folivorra/ziper/app"
+	"github.com/folivorra/ziper/internal/mimetype"
 	"github.com/google/uuid"
 )
 
-//const destDir = "downloads"
+// sniffLen is the amount of bytes http.DetectContentType looks at.
+const sniffLen = 512
 
 type HTTPDownloader struct {
 	client      *http.Client
 	a           *app.App
 	downloadDir string
 	logger      *slog.Logger
+	allowed     mimetype.AllowList
+	retry       RetryPolicy
+	cache       *Cache
+	idleTimeout time.Duration
 }
 
 var _ Downloader = (*HTTPDownloader)(nil)
 
-func NewHTTPDownloader(a *app.App, downloadDir string, logger *slog.Logger, timeout time.Duration) *HTTPDownloader {
+func NewHTTPDownloader(
+	a *app.App,
+	downloadDir string,
+	logger *slog.Logger,
+	timeout time.Duration,
+	idleTimeout time.Duration,
+	transport http.RoundTripper,
+	keepOnShutdown bool,
+	allowed mimetype.AllowList,
+	retry RetryPolicy,
+	cache *Cache,
+) *HTTPDownloader {
 	httpd := &HTTPDownloader{
-		client:      &http.Client{Timeout: timeout},
+		client:      &http.Client{Timeout: timeout, Transport: transport},
 		a:           a,
 		downloadDir: downloadDir,
 		logger:      logger,
+		allowed:     allowed,
+		retry:       retry,
+		cache:       cache,
+		idleTimeout: idleTimeout,
+	}
+
+	if keepOnShutdown {
+		return httpd
 	}
 
 	httpd.a.RegisterCleanup(func(ctx context.Context) {
@@ -45,10 +72,82 @@ func NewHTTPDownloader(a *app.App, downloadDir string, logger *slog.Logger, time
 	return httpd
 }
 
-func (d *HTTPDownloader) DownloadFile(url string, id uint64) error {
+// DownloadFile retries transient failures according to the retry policy,
+// resuming from the partial file when the server supports ranges. The
+// result is returned even on failure so the attempts can be recorded.
+func (d *HTTPDownloader) DownloadFile(ctx context.Context, url string, id uint64, limits Limits) (*Result, error) {
+	filePath, err := d.prepareFilePath(url, id)
+	if err != nil {
+		return &Result{}, err
+	}
+
+	state := &partialDownload{path: filePath, total: -1, limits: limits}
+
+	if entry, fresh, ok := d.cache.Lookup(url); ok {
+		if !fresh {
+			state.cached = &entry
+		} else if err := d.useCached(url, entry, state); !errors.Is(err, errCacheGone) {
+			if err != nil {
+				return &Result{}, err
+			}
+			return &Result{
+				Path:        filePath,
+				FileName:    state.suggestedName(url),
+				Size:        state.written,
+				ContentType: state.contentType,
+				SHA256:      state.sha256,
+				Cached:      true,
+			}, nil
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := d.download(ctx, url, state)
+		if err == nil && !state.fromCache {
+			state.sha256, _, err = hashFile(filePath)
+		}
+		if err == nil {
+			if !state.fromCache && !state.noStore {
+				d.storeInCache(url, state)
+			}
+			return &Result{
+				Path:        filePath,
+				FileName:    state.suggestedName(url),
+				Size:        state.written,
+				ContentType: state.contentType,
+				SHA256:      state.sha256,
+				Attempts:    attempt,
+				Cached:      state.fromCache,
+			}, nil
+		}
+
+		delay, ok := d.retry.next(attempt, err)
+		if !ok {
+			state.reset()
+			d.removePartial(filePath)
+			return &Result{Attempts: attempt}, err
+		}
+
+		d.logger.Warn("retrying download",
+			slog.String("url", url),
+			slog.Int("attempt", attempt),
+			slog.Int64("resume_from", state.resumeOffset()),
+			slog.Duration("delay", delay),
+			slog.String("error", err.Error()),
+		)
+
+		if sleepErr := sleepCtx(ctx, delay); sleepErr != nil {
+			state.reset()
+			d.removePartial(filePath)
+			return &Result{Attempts: attempt}, err
+		}
+	}
+}
+
+func (d *HTTPDownloader) prepareFilePath(url string, id uint64) (string, error) {
 	parsedURL, err := urler.Parse(url)
 	if err != nil {
-		return fmt.Errorf("failed to parse URL: %w", err)
+		return "", fmt.Errorf("failed to parse URL: %w", err)
 	}
 	tokens := strings.Split(parsedURL.Path, "/")
 	baseName := tokens[len(tokens)-1]
@@ -61,31 +160,227 @@ func (d *HTTPDownloader) DownloadFile(url string, id uint64) error {
 
 	err = os.MkdirAll(fmt.Sprintf("%s/task-%d", d.downloadDir, id), os.ModePerm)
 	if err != nil {
-		return fmt.Errorf("failed to create destination directory: %w", err)
+		return "", fmt.Errorf("failed to create destination directory: %w", err)
 	}
 
-	filePath := fmt.Sprintf("%s/task-%d/%s", d.downloadDir, id, fileName)
+	return fmt.Sprintf("%s/task-%d/%s", d.downloadDir, id, fileName), nil
+}
 
-	resp, err := d.client.Get(url)
+// download makes a single attempt. Bytes already in the partial file are
+// kept for the next attempt whenever they can be resumed.
+func (d *HTTPDownloader) download(ctx context.Context, url string, state *partialDownload) error {
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	offset := state.resumeOffset()
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", state.validator())
+	} else if state.cached != nil {
+		if state.cached.ETag != "" {
+			req.Header.Set("If-None-Match", state.cached.ETag)
+		}
+		if state.cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", state.cached.LastModified)
+		}
+	}
+
+	resp, err := d.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to download file: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download file, status: %s", resp.Status)
+	var out *os.File
+	var stream io.Reader = resp.Body
+	if d.idleTimeout > 0 {
+		idle := newIdleReader(resp.Body, d.idleTimeout, cancel)
+		defer idle.stop()
+		stream = idle
 	}
+	body := stream
 
-	out, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+	switch {
+	case resp.StatusCode == http.StatusNotModified && state.cached != nil:
+		d.cache.Revalidated(url)
+		return d.useCached(url, *state.cached, state)
+
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			state.reset()
+			return fmt.Errorf("%w: unexpected content range %q", errResumeRejected, resp.Header.Get("Content-Range"))
+		}
+		if total >= 0 {
+			state.total = total
+		}
+
+		out, err = os.OpenFile(state.path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open partial file: %w", err)
+		}
+
+	case resp.StatusCode == http.StatusOK:
+		// either a fresh start or the server ignored the range because the
+		// file changed, so anything saved before is useless
+		state.reset()
+		if err := state.checkSize(resp.ContentLength); err != nil {
+			return err
+		}
+		state.total = resp.ContentLength
+		state.etag = resp.Header.Get("ETag")
+		state.lastModified = resp.Header.Get("Last-Modified")
+		state.noStore = isPrivate(resp.Header.Values("Cache-Control"))
+		state.fileName = fileNameFromDisposition(resp.Header.Get("Content-Disposition"))
+
+		head := make([]byte, sniffLen)
+		n, err := io.ReadFull(stream, head)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		head = head[:n]
+
+		state.contentType, err = d.detectContentType(head, resp.Header.Get("Content-Type"))
+		if err != nil {
+			return err
+		}
+		if !d.allowed.Allows(state.contentType) {
+			return fmt.Errorf("%w %s", ErrNotSupportedType, state.contentType)
+		}
+
+		out, err = os.Create(state.path)
+		if err != nil {
+			return fmt.Errorf("failed to create file: %w", err)
+		}
+		body = io.MultiReader(bytes.NewReader(head), stream)
+
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		state.reset()
+		return fmt.Errorf("%w: status %s", errResumeRejected, resp.Status)
+
+	default:
+		return newStatusError(resp)
 	}
 	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
+	_, err = io.Copy(&limitedWriter{ctx: ctx, w: out, limits: state.limits, written: &state.written}, body)
 	if err != nil {
 		return fmt.Errorf("failed to save file: %w", err)
 	}
 
+	if state.total >= 0 && state.written != state.total {
+		return fmt.Errorf("%w: got %d of %d bytes", io.ErrUnexpectedEOF, state.written, state.total)
+	}
+
+	return nil
+}
+
+// useCached puts the cached copy in place of the download, applying the
+// same type and size checks a fresh download would get.
+func (d *HTTPDownloader) useCached(url string, entry CacheEntry, state *partialDownload) error {
+	if !d.allowed.Allows(entry.ContentType) {
+		return fmt.Errorf("%w %s", ErrNotSupportedType, entry.ContentType)
+	}
+	if err := state.checkSize(entry.Size); err != nil {
+		return err
+	}
+	if !state.limits.TaskBudget.take(entry.Size) {
+		return fmt.Errorf("%w: task size limit reached", ErrTooLarge)
+	}
+
+	if err := d.cache.Materialize(entry, state.path); err != nil {
+		state.limits.TaskBudget.giveBack(entry.Size)
+		state.cached = nil
+		d.logger.Warn("cached copy unavailable, downloading again",
+			slog.String("url", url),
+			slog.String("error", err.Error()),
+		)
+		return fmt.Errorf("%w: %w", errCacheGone, err)
+	}
+
+	state.written = entry.Size
+	state.contentType = entry.ContentType
+	state.sha256 = entry.SHA256
+	state.fileName = entry.FileName
+	state.fromCache = true
+
+	d.logger.Debug("using cached copy",
+		slog.String("url", url),
+		slog.String("sha256", entry.SHA256),
+	)
+
 	return nil
 }
+
+func (d *HTTPDownloader) storeInCache(url string, state *partialDownload) {
+	err := d.cache.Store(url, state.path, state.sha256, state.written, state.etag, state.lastModified, state.contentType, state.fileName)
+	if err != nil {
+		d.logger.Warn("failed to cache download",
+			slog.String("url", url),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+func (d *HTTPDownloader) removePartial(filePath string) {
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		d.logger.Warn("failed to remove partial file",
+			slog.String("path", filePath),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// sniffable are the types http.DetectContentType recognises by their magic
+// bytes, so a header claiming one of them can be checked against the body.
+var sniffable = map[string]bool{
+	"application/pdf": true,
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+}
+
+// detectContentType trusts the server header, which the validator already
+// checked. The sniffed type only takes over when the header tells nothing,
+// or when the body is clearly an HTML page, such as a login or error page,
+// served under another type. A header claiming a sniffable type the body
+// doesn't start with is rejected; other types, such as docx sniffed as zip,
+// keep the header.
+func (d *HTTPDownloader) detectContentType(head []byte, header string) (string, error) {
+	sniffed := mimetype.Normalize(http.DetectContentType(head))
+	fromHeader := mimetype.Normalize(header)
+
+	if fromHeader == "" || fromHeader == mimetype.Unknown {
+		return sniffed, nil
+	}
+	if sniffed == "text/html" && fromHeader != sniffed {
+		return sniffed, nil
+	}
+	if sniffable[fromHeader] && sniffed != fromHeader {
+		return "", fmt.Errorf("%w %s: content is %s", ErrNotSupportedType, fromHeader, sniffed)
+	}
+
+	return fromHeader, nil
+}
+
+// isPrivate reports whether the response must not be kept in the shared
+// cache: no-store forbids storing it at all and private limits it to the
+// client that asked, while the cache serves every task.
+func isPrivate(cacheControl []string) bool {
+	for _, value := range cacheControl {
+		for _, directive := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			switch strings.ToLower(name) {
+			case "no-store", "private":
+				return true
+			}
+		}
+	}
+	return false
+}
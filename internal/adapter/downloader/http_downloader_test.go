@@ -0,0 +1,86 @@
+package downloader
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDetectContentType(t *testing.T) {
+	pdf := []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	html := []byte("<!DOCTYPE html><html><body>Sign in</body></html>")
+	zipHead := []byte("PK\x03\x04\x14\x00\x00\x00")
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	jpeg := []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00")
+	gif := []byte("GIF89a\x01\x00\x01\x00")
+	webp := []byte("RIFF\x24\x00\x00\x00WEBPVP8 ")
+
+	// an empty want means the file is rejected
+	tests := []struct {
+		name   string
+		head   []byte
+		header string
+		want   string
+	}{
+		{"header agrees", pdf, "application/pdf", "application/pdf"},
+		{"header parameters are dropped", pdf, "application/pdf; qs=0.9", "application/pdf"},
+		{"header wins over a generic sniff", []byte("col1,col2\n1,2\n"), "text/csv", "text/csv"},
+		{"header wins over another binary sniff", zipHead, "application/vnd.openxmlformats-officedocument.wordprocessingml.document", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"html served as pdf", html, "application/pdf", "text/html"},
+		{"html served as html", html, "text/html; charset=utf-8", "text/html"},
+		{"no header", pdf, "", "application/pdf"},
+		{"octet-stream header", pdf, "application/octet-stream", "application/pdf"},
+		{"broken header", pdf, "application/", "application/pdf"},
+		{"png agrees", png, "image/png", "image/png"},
+		{"jpeg agrees", jpeg, "image/jpeg", "image/jpeg"},
+		{"gif agrees", gif, "image/gif", "image/gif"},
+		{"webp agrees", webp, "image/webp", "image/webp"},
+		{"text served as pdf", []byte("col1,col2\n1,2\n"), "application/pdf", ""},
+		{"zip served as pdf", zipHead, "application/pdf", ""},
+		{"png served as jpeg", png, "image/jpeg", ""},
+		{"jpeg served as png", jpeg, "image/png", ""},
+		{"pdf served as gif", pdf, "image/gif", ""},
+		{"gif served as webp", gif, "image/webp", ""},
+	}
+
+	d := &HTTPDownloader{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.detectContentType(tt.head, tt.header)
+			if tt.want == "" {
+				if !errors.Is(err, ErrNotSupportedType) {
+					t.Errorf("detectContentType(%q) error = %v, want %v", tt.header, err, ErrNotSupportedType)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("detectContentType(%q) = %q, %v, want %q", tt.header, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPrivate(t *testing.T) {
+	tests := []struct {
+		name  string
+		value []string
+		want  bool
+	}{
+		{"no header", nil, false},
+		{"public", []string{"public, max-age=3600"}, false},
+		{"no-store", []string{"no-store"}, true},
+		{"private", []string{"max-age=60, private"}, true},
+		{"private fields", []string{`private="Set-Cookie"`}, true},
+		{"upper case", []string{"No-Store"}, true},
+		{"second header", []string{"max-age=60", "private"}, true},
+		{"no-cache only", []string{"no-cache"}, false},
+		{"similar name", []string{"x-private-hint=1"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPrivate(tt.value); got != tt.want {
+				t.Errorf("isPrivate(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
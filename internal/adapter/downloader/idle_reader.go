@@ -0,0 +1,46 @@
+package downloader
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+var errStalled = errors.New("download stalled")
+
+// idleReader cancels the request when a single read of the body waits
+// longer than timeout, so a stalled server is dropped while a slow but
+// steady download is not limited in total time. Only the time spent
+// waiting for the server counts, not the time the body sits unread.
+type idleReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+func newIdleReader(r io.Reader, timeout time.Duration, cancel func()) *idleReader {
+	ir := &idleReader{r: r, timeout: timeout}
+	ir.timer = time.AfterFunc(timeout, func() {
+		ir.stalled.Store(true)
+		cancel()
+	})
+	ir.timer.Stop()
+	return ir
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	r.timer.Reset(r.timeout)
+	n, err := r.r.Read(p)
+	r.timer.Stop()
+
+	if err != nil && r.stalled.Load() {
+		return n, errStalled
+	}
+	return n, err
+}
+
+func (r *idleReader) stop() {
+	r.timer.Stop()
+}
@@ -1,5 +1,19 @@
 package downloader
 
+import "context"
+
+// Result describes a finished download. FileName is the name suggested by
+// the server or the URL; it is not sanitised.
+type Result struct {
+	Path        string
+	FileName    string
+	Size        int64
+	ContentType string
+	SHA256      string
+	Attempts    int
+	Cached      bool
+}
+
 type Downloader interface {
-	DownloadFile(url string, id uint64) error
+	DownloadFile(ctx context.Context, url string, id uint64, limits Limits) (*Result, error)
 }
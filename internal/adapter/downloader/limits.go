@@ -0,0 +1,83 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+type RateLimiter interface {
+	WaitN(ctx context.Context, n int) error
+}
+
+// Limits bounds a single download. Zero MaxFileSize and nil TaskBudget or
+// RateLimiter mean no limit.
+type Limits struct {
+	MaxFileSize int64
+	TaskBudget  *Budget
+	RateLimiter RateLimiter
+}
+
+// Budget is the amount of bytes left for all downloads of one task; it is
+// shared between the concurrent downloads.
+type Budget struct {
+	remaining atomic.Int64
+}
+
+func NewBudget(size int64) *Budget {
+	b := &Budget{}
+	b.remaining.Store(size)
+	return b
+}
+
+func (b *Budget) take(n int64) bool {
+	if b == nil {
+		return true
+	}
+	if b.remaining.Add(-n) < 0 {
+		b.remaining.Add(n)
+		return false
+	}
+	return true
+}
+
+func (b *Budget) giveBack(n int64) {
+	if b != nil && n > 0 {
+		b.remaining.Add(n)
+	}
+}
+
+// limitedWriter fails the copy as soon as the file or the task goes over
+// its limit, so an endless response can't fill the disk.
+type limitedWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	limits  Limits
+	written *int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	n := int64(len(p))
+
+	if l.limits.MaxFileSize > 0 && *l.written+n > l.limits.MaxFileSize {
+		return 0, fmt.Errorf("%w: over %d bytes", ErrTooLarge, l.limits.MaxFileSize)
+	}
+
+	if l.limits.RateLimiter != nil {
+		if err := l.limits.RateLimiter.WaitN(l.ctx, len(p)); err != nil {
+			return 0, err
+		}
+	}
+
+	if !l.limits.TaskBudget.take(n) {
+		return 0, fmt.Errorf("%w: task size limit reached", ErrTooLarge)
+	}
+
+	written, err := l.w.Write(p)
+	*l.written += int64(written)
+	if int64(written) < n {
+		l.limits.TaskBudget.giveBack(n - int64(written))
+	}
+	return written, err
+}
@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// errResumeRejected means the saved bytes had to be dropped; the next
+// attempt starts over, so it is always worth retrying.
+var errResumeRejected = errors.New("resume rejected")
+
+// partialDownload carries what is needed to continue a download with a
+// Range request after an interrupted attempt.
+type partialDownload struct {
+	path         string
+	written      int64
+	total        int64
+	etag         string
+	lastModified string
+	contentType  string
+	fileName     string
+	sha256       string
+	limits       Limits
+	cached       *CacheEntry
+	fromCache    bool
+	noStore      bool
+}
+
+// resumeOffset is zero when there is nothing to resume or the response had
+// no validator, since a Range request without If-Range could stitch
+// together two different versions of the file.
+func (p *partialDownload) resumeOffset() int64 {
+	if p.validator() == "" {
+		return 0
+	}
+	return p.written
+}
+
+// validator prefers a strong ETag; weak ones aren't allowed in If-Range.
+func (p *partialDownload) validator() string {
+	if p.etag != "" && !strings.HasPrefix(p.etag, "W/") {
+		return p.etag
+	}
+	return p.lastModified
+}
+
+// suggestedName prefers the name from Content-Disposition over the URL.
+func (p *partialDownload) suggestedName(url string) string {
+	if p.fileName != "" {
+		return p.fileName
+	}
+	return fileNameFromURL(url)
+}
+
+// reset drops the saved bytes and returns them to the task budget.
+func (p *partialDownload) reset() {
+	p.limits.TaskBudget.giveBack(p.written)
+	p.written = 0
+	p.total = -1
+	p.etag = ""
+	p.lastModified = ""
+}
+
+// checkSize rejects a response whose announced length is already over the
+// file limit; the task budget is only charged for bytes actually written.
+func (p *partialDownload) checkSize(contentLength int64) error {
+	if p.limits.MaxFileSize > 0 && contentLength > p.limits.MaxFileSize {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrTooLarge, contentLength, p.limits.MaxFileSize)
+	}
+	return nil
+}
+
+// parseContentRange reads "bytes start-end/total"; total is -1 for "*".
+func parseContentRange(value string) (start, total int64, ok bool) {
+	rest, found := strings.CutPrefix(value, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+
+	rng, size, found := strings.Cut(rest, "/")
+	if !found {
+		return 0, 0, false
+	}
+
+	startStr, _, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	if size == "*" {
+		return start, -1, true
+	}
+
+	total, err = strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return start, total, true
+}
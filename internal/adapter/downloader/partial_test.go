@@ -0,0 +1,64 @@
+package downloader
+
+import "testing"
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		value     string
+		wantStart int64
+		wantTotal int64
+		wantOK    bool
+	}{
+		{"bytes 0-499/1234", 0, 1234, true},
+		{"bytes 500-1233/1234", 500, 1234, true},
+		{"bytes 42-99/*", 42, -1, true},
+		{"", 0, 0, false},
+		{"500-1233/1234", 0, 0, false},
+		{"items 0-1/2", 0, 0, false},
+		{"bytes 0-499", 0, 0, false},
+		{"bytes 500/1234", 0, 0, false},
+		{"bytes */1234", 0, 0, false},
+		{"bytes x-499/1234", 0, 0, false},
+		{"bytes 0-499/big", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			start, total, ok := parseContentRange(tt.value)
+			if ok != tt.wantOK {
+				t.Fatalf("parseContentRange(%q) ok = %v, want %v", tt.value, ok, tt.wantOK)
+			}
+			if ok && (start != tt.wantStart || total != tt.wantTotal) {
+				t.Errorf("parseContentRange(%q) = %d, %d, want %d, %d", tt.value, start, total, tt.wantStart, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestResumeOffset(t *testing.T) {
+	tests := []struct {
+		name          string
+		etag          string
+		lastModified  string
+		wantValidator string
+		wantOffset    int64
+	}{
+		{"strong etag", `"v1"`, "", `"v1"`, 100},
+		{"etag preferred", `"v1"`, "Wed, 21 Oct 2015 07:28:00 GMT", `"v1"`, 100},
+		{"weak etag falls back", `W/"v1"`, "Wed, 21 Oct 2015 07:28:00 GMT", "Wed, 21 Oct 2015 07:28:00 GMT", 100},
+		{"only weak etag", `W/"v1"`, "", "", 0},
+		{"no validator", "", "", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &partialDownload{written: 100, etag: tt.etag, lastModified: tt.lastModified}
+			if got := p.validator(); got != tt.wantValidator {
+				t.Errorf("validator() = %q, want %q", got, tt.wantValidator)
+			}
+			if got := p.resumeOffset(); got != tt.wantOffset {
+				t.Errorf("resumeOffset() = %d, want %d", got, tt.wantOffset)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/folivorra/ziper/internal/netguard"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func dialError(err error) error {
+	return &url.Error{Op: "Get", URL: "http://example.com/a.pdf", Err: &net.OpError{
+		Op:  "dial",
+		Net: "tcp",
+		Err: &os.SyscallError{Syscall: "connect", Err: err},
+	}}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"server error", &StatusError{Code: 503}, true},
+		{"too many requests", &StatusError{Code: 429}, true},
+		{"request timeout", &StatusError{Code: 408}, true},
+		{"not found", &StatusError{Code: 404}, false},
+		{"forbidden", &StatusError{Code: 403}, false},
+		{"timeout", fmt.Errorf("failed to download file: %w", &url.Error{Op: "Get", Err: timeoutError{}}), true},
+		{"deadline", context.DeadlineExceeded, true},
+		{"connection reset", &net.OpError{Op: "read", Err: &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}}, true},
+		{"server closed connection", &url.Error{Op: "Get", Err: io.EOF}, true},
+		{"truncated body", fmt.Errorf("%w: got 1 of 2 bytes", io.ErrUnexpectedEOF), true},
+		{"stalled", fmt.Errorf("failed to save file: %w", errStalled), true},
+		{"resume rejected", fmt.Errorf("%w: status 416", errResumeRejected), true},
+		{"cache gone", fmt.Errorf("%w: no such file", errCacheGone), true},
+		{"connection refused", dialError(syscall.ECONNREFUSED), false},
+		{"unknown host", &url.Error{Op: "Get", Err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "nope.invalid", IsNotFound: true}}}, false},
+		{"tls", &url.Error{Op: "Get", Err: x509.UnknownAuthorityError{}}, false},
+		{"cancelled", fmt.Errorf("failed to save file: %w", context.Canceled), false},
+		{"blocked", fmt.Errorf("failed to download file: %w", netguard.ErrBlocked), false},
+		{"not supported type", fmt.Errorf("%w text/html", ErrNotSupportedType), false},
+		{"too large", ErrTooLarge, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyNext(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 4, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	tests := []struct {
+		name     string
+		attempt  int
+		err      error
+		wantOK   bool
+		maxDelay time.Duration
+		exact    bool
+	}{
+		{"first retry", 1, &StatusError{Code: 500}, true, 100 * time.Millisecond, false},
+		{"backoff doubles", 3, &StatusError{Code: 500}, true, 400 * time.Millisecond, false},
+		{"attempts used up", 4, &StatusError{Code: 500}, false, 0, false},
+		{"permanent error", 1, &StatusError{Code: 404}, false, 0, false},
+		{"retry after", 1, &StatusError{Code: 503, RetryAfter: 300 * time.Millisecond}, true, 300 * time.Millisecond, true},
+		{"retry after is capped", 1, &StatusError{Code: 429, RetryAfter: time.Minute}, true, time.Second, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, ok := policy.next(tt.attempt, tt.err)
+			if ok != tt.wantOK {
+				t.Fatalf("next(%d) ok = %v, want %v", tt.attempt, ok, tt.wantOK)
+			}
+			if tt.exact && delay != tt.maxDelay {
+				t.Errorf("next(%d) delay = %v, want %v", tt.attempt, delay, tt.maxDelay)
+			}
+			if delay < 0 || delay > tt.maxDelay {
+				t.Errorf("next(%d) delay = %v, want at most %v", tt.attempt, delay, tt.maxDelay)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyNextLongBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 100, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	// the shift overflows long before the attempts run out
+	for attempt := 1; attempt < 100; attempt++ {
+		delay, ok := policy.next(attempt, errStalled)
+		if !ok {
+			t.Fatalf("next(%d) gave up", attempt)
+		}
+		if delay < 0 || delay > policy.MaxDelay {
+			t.Fatalf("next(%d) delay = %v, want at most %v", attempt, delay, policy.MaxDelay)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"0", 0},
+		{"-3", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(future); got <= 59*time.Minute || got > time.Hour {
+		t.Errorf("parseRetryAfter(%q) = %v, want about an hour", future, got)
+	}
+}
@@ -6,14 +6,59 @@ import (
 	"time"
 )
 
+const (
+	RepoTypeMemory = "memory"
+	RepoTypeFile   = "file"
+
+	ArchiveModeFile   = "file"
+	ArchiveModeStream = "stream"
+)
+
 type Config struct {
-	Port           string        `env:"PORT" envDefault:"8080"`
-	Timeout        time.Duration `env:"TIMEOUT" envDefault:"5s"`
-	MaxTasks       uint64        `env:"MAX_TASKS" envDefault:"3"`
-	MaxFilesInTask uint64        `env:"MAX_FILES" envDefault:"3"`
-	ArchDir        string        `env:"ARCH_DIR" envDefault:"archives"`
-	DownloadDir    string        `env:"DOWNLOAD_DIR" envDefault:"downloads"`
-	WorkersNum     int           `env:"WORKERS_NUM" envDefault:"3"`
+	Port                  string        `env:"PORT" envDefault:"8080"`
+	Timeout               time.Duration `env:"TIMEOUT" envDefault:"5s"`
+	DownloadTimeout       time.Duration `env:"DOWNLOAD_TIMEOUT" envDefault:"10m"`
+	DialTimeout           time.Duration `env:"DIAL_TIMEOUT" envDefault:"5s"`
+	KeepAlive             time.Duration `env:"KEEP_ALIVE" envDefault:"30s"`
+	TLSHandshakeTimeout   time.Duration `env:"TLS_HANDSHAKE_TIMEOUT" envDefault:"5s"`
+	ResponseHeaderTimeout time.Duration `env:"RESPONSE_HEADER_TIMEOUT" envDefault:"10s"`
+	IdleReadTimeout       time.Duration `env:"IDLE_READ_TIMEOUT" envDefault:"30s"`
+	IdleConnTimeout       time.Duration `env:"IDLE_CONN_TIMEOUT" envDefault:"90s"`
+	MaxIdleConns          int           `env:"MAX_IDLE_CONNS" envDefault:"100"`
+	MaxIdleConnsPerHost   int           `env:"MAX_IDLE_CONNS_PER_HOST" envDefault:"4"`
+	MaxConnsPerHost       int           `env:"MAX_CONNS_PER_HOST" envDefault:"8"`
+	MaxTasks              uint64        `env:"MAX_TASKS" envDefault:"3"`
+	MaxFilesInTask        uint64        `env:"MAX_FILES" envDefault:"3"`
+	ArchDir               string        `env:"ARCH_DIR" envDefault:"archives"`
+	DownloadDir           string        `env:"DOWNLOAD_DIR" envDefault:"downloads"`
+	WorkersNum            int           `env:"WORKERS_NUM" envDefault:"3"`
+	AutoSubmit            bool          `env:"AUTO_SUBMIT" envDefault:"false"`
+	AllowedTypes          []string      `env:"ALLOWED_MIME_TYPES" envSeparator:"," envDefault:"application/pdf,image/jpeg"`
+	MaxFileSize           int64         `env:"MAX_FILE_SIZE" envDefault:"104857600"`
+	MaxTaskSize           int64         `env:"MAX_TASK_SIZE" envDefault:"314572800"`
+	SSRFProtection        bool          `env:"SSRF_PROTECTION" envDefault:"true"`
+	BlockedCIDRs          []string      `env:"BLOCKED_CIDRS" envSeparator:","`
+	AllowedHosts          []string      `env:"ALLOWED_HOSTS" envSeparator:","`
+	DeniedHosts           []string      `env:"DENIED_HOSTS" envSeparator:","`
+	HostMaxDownloads      int           `env:"HOST_MAX_DOWNLOADS" envDefault:"4"`
+	HostRateLimit         int64         `env:"HOST_RATE_LIMIT" envDefault:"0"`
+	GlobalRateLimit       int64         `env:"GLOBAL_RATE_LIMIT" envDefault:"0"`
+	CacheEnabled          bool          `env:"CACHE_ENABLED" envDefault:"true"`
+	CacheDir              string        `env:"CACHE_DIR" envDefault:"data/cache"`
+	CacheTTL              time.Duration `env:"CACHE_TTL" envDefault:"10m"`
+	CacheMaxSize          int64         `env:"CACHE_MAX_SIZE" envDefault:"1073741824"`
+	TaskTTL               time.Duration `env:"TASK_TTL" envDefault:"24h"`
+	JanitorInterval       time.Duration `env:"JANITOR_INTERVAL" envDefault:"1m"`
+	LockTimeout           time.Duration `env:"LOCK_TIMEOUT" envDefault:"30s"`
+	RetryAttempts         int           `env:"RETRY_MAX_ATTEMPTS" envDefault:"3"`
+	RetryBaseDelay        time.Duration `env:"RETRY_BASE_DELAY" envDefault:"500ms"`
+	RetryMaxDelay         time.Duration `env:"RETRY_MAX_DELAY" envDefault:"10s"`
+	ArchiveMode           string        `env:"ARCHIVE_MODE" envDefault:"file"`
+	Compression           string        `env:"ARCHIVE_COMPRESSION" envDefault:"deflate"`
+	CompressLevel         int           `env:"ARCHIVE_COMPRESSION_LEVEL" envDefault:"-1"`
+	ZstdLevel             int           `env:"ZSTD_COMPRESSION_LEVEL" envDefault:"0"`
+	RepoType              string        `env:"REPO_TYPE" envDefault:"memory"`
+	RepoPath              string        `env:"REPO_PATH" envDefault:"data/tasks.json"`
 }
 
 func NewConfig() Config {
@@ -23,3 +68,11 @@ func NewConfig() Config {
 	}
 	return cfg
 }
+
+func (c Config) IsPersistent() bool {
+	return c.RepoType == RepoTypeFile
+}
+
+func (c Config) IsStreamingArchives() bool {
+	return c.ArchiveMode == ArchiveModeStream
+}
@@ -0,0 +1,51 @@
+package httptransport
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/folivorra/ziper/internal/netguard"
+)
+
+type Config struct {
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+}
+
+// New builds the transport shared by the validator and the downloader, so
+// HEAD checks and downloads of the same host reuse pooled connections.
+// With a guard, proxies are disabled since it would only see the proxy
+// address.
+func New(cfg Config, guard *netguard.Guard) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: cfg.KeepAlive,
+	}
+
+	dial := netguard.DialFunc(dialer.DialContext)
+	proxy := http.ProxyFromEnvironment
+	if guard != nil {
+		dial = guard.DialContext(dialer)
+		proxy = nil
+	}
+
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dial,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		ExpectContinueTimeout: time.Second,
+	}
+}
@@ -0,0 +1,49 @@
+package mimetype
+
+import (
+	"mime"
+	"strings"
+)
+
+// Unknown is what servers and http.DetectContentType report when they
+// can't tell the type, so it never decides anything on its own.
+const Unknown = "application/octet-stream"
+
+// AllowList holds media types like "application/pdf"; a "image/*" entry
+// allows every subtype.
+type AllowList []string
+
+func NewAllowList(types []string) AllowList {
+	list := make(AllowList, 0, len(types))
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" {
+			list = append(list, t)
+		}
+	}
+	return list
+}
+
+func (l AllowList) Allows(contentType string) bool {
+	mediaType := Normalize(contentType)
+	if mediaType == "" {
+		return false
+	}
+
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, allowed := range l {
+		if allowed == mediaType || allowed == major+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalize strips parameters such as charset from a Content-Type value.
+func Normalize(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
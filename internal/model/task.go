@@ -1,32 +1,88 @@
 package model
 
+import "time"
+
 type (
-	TaskStatus string
-	FileStatus string
+	TaskStatus    string
+	FileStatus    string
+	ArchiveFormat string
+	TaskPriority  string
 )
 
 const (
-	TaskStatusAccepted   TaskStatus = "accepted"
-	TaskStatusInProgress TaskStatus = "in_progress"
-	TaskStatusCompleted  TaskStatus = "completed"
+	TaskStatusAccepted           TaskStatus = "accepted"
+	TaskStatusQueued             TaskStatus = "queued"
+	TaskStatusInProgress         TaskStatus = "in_progress"
+	TaskStatusCompleted          TaskStatus = "completed"
+	TaskStatusPartiallyCompleted TaskStatus = "partially_completed"
+	TaskStatusFailed             TaskStatus = "failed"
+	TaskStatusCancelled          TaskStatus = "cancelled"
+	TaskStatusExpired            TaskStatus = "expired"
 
+	FileStatusValidating       FileStatus = "validating"
 	FileStatusAccepted         FileStatus = "accepted"
 	FileStatusCompleted        FileStatus = "completed"
 	FileStatusFailed           FileStatus = "failed"
 	FileStatusInvalidURL       FileStatus = "invalid_url"
 	FileStatusNotReachable     FileStatus = "not_reachable"
 	FileStatusNotSupportedType FileStatus = "not_supported_type"
+	FileStatusTooLarge         FileStatus = "too_large"
+	FileStatusBlockedURL       FileStatus = "blocked_url"
+	FileStatusChecksumMismatch FileStatus = "checksum_mismatch"
+
+	ArchiveFormatZip    ArchiveFormat = "zip"
+	ArchiveFormatTarGz  ArchiveFormat = "tar.gz"
+	ArchiveFormatTarZst ArchiveFormat = "tar.zst"
+
+	TaskPriorityHigh   TaskPriority = "high"
+	TaskPriorityNormal TaskPriority = "normal"
+	TaskPriorityLow    TaskPriority = "low"
 )
 
 type Task struct {
 	ID          uint64
 	Status      TaskStatus
 	Files       []*File
+	Format      ArchiveFormat
+	ArchiveName string
 	ArchivePath string
 	ArchiveURL  string
+	Error       string
+	Owner       string
+	Priority    TaskPriority
+	CreatedAt   time.Time
+	QueuedAt    time.Time
+	ExpiresAt   time.Time
+	// SubmitPending means the task goes to the queue as soon as none of
+	// its files is validating.
+	SubmitPending bool
 }
 
 type File struct {
-	Status FileStatus
-	URL    string
+	Status       FileStatus
+	URL          string
+	ExpectedSize int64
+	ExpectedSHA  string
+	ClientName   string
+	Name         string
+	Size         int64
+	SHA256       string
+	ContentType  string
+	Error        string
+	Attempts     int
+	Cached       bool
+	StartedAt    time.Time
+	FinishedAt   time.Time
+}
+
+// Clone returns a copy of the task that doesn't share files with the
+// original, so it can be read after the task lock is released.
+func (t *Task) Clone() *Task {
+	c := *t
+	c.Files = make([]*File, len(t.Files))
+	for i, f := range t.Files {
+		file := *f
+		c.Files[i] = &file
+	}
+	return &c
 }
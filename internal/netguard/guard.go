@@ -0,0 +1,150 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+var ErrBlocked = errors.New("blocked address")
+
+// defaultBlocked covers ranges that netip.Addr has no predicate for.
+var defaultBlocked = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("255.255.255.255/32"),
+}
+
+// Guard resolves hosts itself and dials only the checked addresses, so a
+// redirect or a DNS answer changing between check and connect can't reach
+// loopback, link-local, private or configured ranges.
+type Guard struct {
+	blocked    []netip.Prefix
+	allowHosts []string
+	denyHosts  []string
+	resolver   *net.Resolver
+}
+
+// NewGuard takes extra CIDRs to block and host lists. A host entry matches
+// the host itself and, when it starts with a dot, all of its subdomains.
+// Allowed hosts skip the address check, denied hosts are always rejected.
+func NewGuard(blockedCIDRs, allowHosts, denyHosts []string) (*Guard, error) {
+	g := &Guard{
+		blocked:    append([]netip.Prefix(nil), defaultBlocked...),
+		allowHosts: normalizeHosts(allowHosts),
+		denyHosts:  normalizeHosts(denyHosts),
+		resolver:   net.DefaultResolver,
+	}
+
+	for _, cidr := range blockedCIDRs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blocked cidr %q: %w", cidr, err)
+		}
+		g.blocked = append(g.blocked, prefix.Masked())
+	}
+
+	return g, nil
+}
+
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// DialContext wraps the dialer so that it only connects to checked
+// addresses.
+func (g *Guard) DialContext(dialer *net.Dialer) DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+		if matchHost(g.denyHosts, host) {
+			return nil, fmt.Errorf("%w: host %s is denied", ErrBlocked, host)
+		}
+
+		if matchHost(g.allowHosts, host) {
+			return dialer.DialContext(ctx, network, address)
+		}
+
+		addrs, err := g.resolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+			if err := g.checkAddr(addr); err != nil {
+				return nil, fmt.Errorf("%w: %s resolves to %s", ErrBlocked, host, addr.Unmap())
+			}
+		}
+
+		var lastErr error
+		for _, addr := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no addresses for %s", host)
+		}
+		return nil, lastErr
+	}
+}
+
+func (g *Guard) checkAddr(addr netip.Addr) error {
+	addr = addr.Unmap()
+
+	if addr.IsLoopback() ||
+		addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() ||
+		addr.IsUnspecified() {
+		return ErrBlocked
+	}
+
+	for _, prefix := range g.blocked {
+		if prefix.Contains(addr) {
+			return ErrBlocked
+		}
+	}
+
+	return nil
+}
+
+func matchHost(list []string, host string) bool {
+	for _, entry := range list {
+		if entry == host {
+			return true
+		}
+		if strings.HasPrefix(entry, ".") && (strings.HasSuffix(host, entry) || host == entry[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeHosts(hosts []string) []string {
+	list := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		h = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(h), "."))
+		if h != "" {
+			list = append(list, h)
+		}
+	}
+	return list
+}
@@ -0,0 +1,133 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestCheckAddr(t *testing.T) {
+	g, err := NewGuard([]string{"203.0.113.0/24", " 2001:db8::/32 ", ""}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewGuard: %v", err)
+	}
+
+	tests := []struct {
+		addr    string
+		blocked bool
+	}{
+		{"93.184.216.34", false},
+		{"2606:2800:220:1:248:1893:25c8:1946", false},
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"fd00::1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"224.0.0.1", true},
+		{"0.0.0.0", true},
+		{"::", true},
+		{"100.64.0.1", true},
+		{"198.18.0.1", true},
+		{"255.255.255.255", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"203.0.113.7", true},
+		{"2001:db8::1", true},
+		{"203.0.114.1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			err := g.checkAddr(netip.MustParseAddr(tt.addr))
+			if got := errors.Is(err, ErrBlocked); got != tt.blocked {
+				t.Errorf("checkAddr(%s) = %v, want blocked %v", tt.addr, err, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	list := normalizeHosts([]string{"Example.com", " .corp.local. ", ""})
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"www.example.com", false},
+		{"notexample.com", false},
+		{"corp.local", true},
+		{"files.corp.local", true},
+		{"a.b.corp.local", true},
+		{"evilcorp.local", false},
+		{"corp.local.evil.com", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := matchHost(list, tt.host); got != tt.want {
+				t.Errorf("matchHost(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewGuardInvalidCIDR(t *testing.T) {
+	if _, err := NewGuard([]string{"10.0.0.0/33"}, nil, nil); err == nil {
+		t.Fatal("NewGuard accepted an invalid cidr")
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	tests := []struct {
+		name    string
+		allow   []string
+		deny    []string
+		host    string
+		blocked bool
+	}{
+		{"loopback is blocked", nil, nil, "127.0.0.1", true},
+		{"allowed host skips the check", []string{"127.0.0.1"}, nil, "127.0.0.1", false},
+		{"denied host wins", []string{"127.0.0.1"}, []string{"127.0.0.1"}, "127.0.0.1", true},
+		{"denied by suffix", nil, []string{".example.com"}, "files.example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGuard(nil, tt.allow, tt.deny)
+			if err != nil {
+				t.Fatalf("NewGuard: %v", err)
+			}
+
+			conn, err := g.DialContext(&net.Dialer{})(context.Background(), "tcp", net.JoinHostPort(tt.host, port))
+			if conn != nil {
+				conn.Close()
+			}
+			if got := errors.Is(err, ErrBlocked); got != tt.blocked {
+				t.Errorf("dial %s = %v, want blocked %v", tt.host, err, tt.blocked)
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/folivorra/ziper/internal/model"
+)
+
+// compactSlack is how many superseded records the journal may hold on top
+// of twice the live records before it is rewritten.
+const compactSlack = 64
+
+// journalRecord is one line of the tasks file: the new state of a task,
+// its removal with the tombstone time, or the highest id handed out, which
+// compaction writes so the ids of deleted tasks aren't reused.
+type journalRecord struct {
+	ID        uint64          `json:"id,omitempty"`
+	Task      json.RawMessage `json:"task,omitempty"`
+	Deleted   bool            `json:"deleted,omitempty"`
+	RemovedAt *time.Time      `json:"removed_at,omitempty"`
+	LastID    uint64          `json:"last_id,omitempty"`
+}
+
+// FileTaskRepo keeps the tasks in an append-only journal, so a change costs
+// one synced line instead of rewriting every task. The journal is compacted
+// when it holds mostly superseded records and on every start.
+type FileTaskRepo struct {
+	mu        sync.RWMutex
+	path      string
+	file      *os.File
+	records   int
+	tasks     map[uint64]*model.Task
+	raw       map[uint64]json.RawMessage
+	snapshots map[uint64]*model.Task
+	removed   map[uint64]time.Time
+	lastID    uint64
+}
+
+var _ TaskRepo = (*FileTaskRepo)(nil)
+
+func NewFileTaskRepo(path string) (*FileTaskRepo, error) {
+	r := &FileTaskRepo{
+		path:      path,
+		tasks:     make(map[uint64]*model.Task),
+		raw:       make(map[uint64]json.RawMessage),
+		snapshots: make(map[uint64]*model.Task),
+		removed:   make(map[uint64]time.Time),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create tasks file directory: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read tasks file: %w", err)
+	}
+
+	if err := r.load(data); err != nil {
+		return nil, err
+	}
+
+	for id, raw := range r.raw {
+		task := &model.Task{}
+		if err := json.Unmarshal(raw, task); err != nil {
+			return nil, fmt.Errorf("failed to decode task %d: %w", id, err)
+		}
+		r.tasks[id] = task
+		r.snapshots[id] = task.Clone()
+	}
+
+	// drops superseded records and a torn last line left by a crash
+	if err := r.compact(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// load replays the journal.
+func (r *FileTaskRepo) load(data []byte) error {
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var rec journalRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			// only the last line can be torn by a crash mid-append
+			if i == len(lines)-1 {
+				break
+			}
+			return fmt.Errorf("failed to decode tasks file: %w", err)
+		}
+
+		r.lastID = max(r.lastID, rec.ID, rec.LastID)
+
+		switch {
+		case rec.Deleted:
+			delete(r.raw, rec.ID)
+			if rec.RemovedAt != nil {
+				r.removed[rec.ID] = *rec.RemovedAt
+			}
+		case rec.Task != nil:
+			r.raw[rec.ID] = rec.Task
+		}
+	}
+
+	return nil
+}
+
+// Save encodes only the given task, so the caller must hold its lock.
+func (r *FileTaskRepo) Save(task *model.Task) error {
+	raw, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to encode task %d: %w", task.ID, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.append(journalRecord{ID: task.ID, Task: raw}); err != nil {
+		return err
+	}
+
+	r.tasks[task.ID] = task
+	r.raw[task.ID] = raw
+	r.snapshots[task.ID] = task.Clone()
+	r.lastID = max(r.lastID, task.ID)
+
+	return r.maybeCompact()
+}
+
+func (r *FileTaskRepo) GetByID(id uint64) (*model.Task, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	task, ok := r.tasks[id]
+
+	if !ok {
+		return nil, fmt.Errorf("task not found")
+	}
+
+	return task, nil
+}
+
+func (r *FileTaskRepo) GetAll() ([]*model.Task, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tasks := make([]*model.Task, 0, len(r.tasks))
+	for _, task := range r.tasks {
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
+
+func (r *FileTaskRepo) Delete(id uint64, removedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tasks[id]; !ok {
+		return nil
+	}
+
+	if err := r.append(journalRecord{ID: id, Deleted: true, RemovedAt: &removedAt}); err != nil {
+		return err
+	}
+
+	delete(r.tasks, id)
+	delete(r.raw, id)
+	delete(r.snapshots, id)
+	r.removed[id] = removedAt
+
+	return r.maybeCompact()
+}
+
+func (r *FileTaskRepo) RemovedAt(id uint64) (time.Time, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	at, ok := r.removed[id]
+	return at, ok
+}
+
+// ForgetRemoved rewrites the journal when a tombstone was dropped, so it
+// doesn't come back on the next start.
+func (r *FileTaskRepo) ForgetRemoved(before time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if forgetRemoved(r.removed, before) == 0 {
+		return nil
+	}
+	return r.compact()
+}
+
+func (r *FileTaskRepo) LastID() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.lastID
+}
+
+func (r *FileTaskRepo) List(filter Filter, page Page) ([]*model.Task, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return listSnapshots(r.snapshots, filter, page), nil
+}
+
+func (r *FileTaskRepo) Count(filter Filter) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return countMatching(r.snapshots, filter), nil
+}
+
+func (r *FileTaskRepo) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// append writes the record and syncs it, so a saved change survives a
+// crash or power loss.
+func (r *FileTaskRepo) append(rec journalRecord) error {
+	if r.file == nil {
+		return errors.New("tasks file is closed")
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode task %d: %w", rec.ID, err)
+	}
+
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write tasks file: %w", err)
+	}
+	if err := r.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync tasks file: %w", err)
+	}
+	r.records++
+
+	return nil
+}
+
+func (r *FileTaskRepo) maybeCompact() error {
+	if r.records <= 2*r.liveRecords()+compactSlack {
+		return nil
+	}
+	return r.compact()
+}
+
+// liveRecords is how many records a compacted journal holds: the last id,
+// one per task and one per tombstone.
+func (r *FileTaskRepo) liveRecords() int {
+	return 1 + len(r.raw) + len(r.removed)
+}
+
+// compact replaces the journal with the last id, one record per task and
+// one per tombstone. The new file is synced before the rename and the
+// directory after it, so after a crash either the old or the new journal
+// is in place.
+func (r *FileTaskRepo) compact() error {
+	records := make([]journalRecord, 0, r.liveRecords())
+	records = append(records, journalRecord{LastID: r.lastID})
+	for id, raw := range r.raw {
+		records = append(records, journalRecord{ID: id, Task: raw})
+	}
+	for id, removedAt := range r.removed {
+		records = append(records, journalRecord{ID: id, Deleted: true, RemovedAt: &removedAt})
+	}
+
+	var buf bytes.Buffer
+	for _, rec := range records {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("failed to encode task %d: %w", rec.ID, err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	tmp := r.path + ".tmp"
+	if err := writeSynced(tmp, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write tasks file: %w", err)
+	}
+
+	if err := os.Rename(tmp, r.path); err != nil {
+		return fmt.Errorf("failed to replace tasks file: %w", err)
+	}
+	if err := syncDir(filepath.Dir(r.path)); err != nil {
+		return fmt.Errorf("failed to sync tasks file directory: %w", err)
+	}
+
+	if r.file != nil {
+		r.file.Close()
+	}
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open tasks file: %w", err)
+	}
+	r.file = file
+	r.records = len(records)
+
+	return nil
+}
+
+func writeSynced(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/folivorra/ziper/internal/model"
+)
+
+func openFileRepo(t *testing.T, path string) *FileTaskRepo {
+	t.Helper()
+	repo, err := NewFileTaskRepo(path)
+	if err != nil {
+		t.Fatalf("NewFileTaskRepo: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func TestFileTaskRepoRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "tasks.json")
+
+	saved := &model.Task{
+		ID:          7,
+		Status:      model.TaskStatusPartiallyCompleted,
+		Format:      model.ArchiveFormatTarGz,
+		ArchiveName: "reports.tar.gz",
+		ArchivePath: "archives/task-7.tar.gz",
+		ArchiveURL:  "http://localhost:8080/archives/task-7.tar.gz",
+		Error:       "1 of 2 files failed",
+		Owner:       "alice",
+		Priority:    model.TaskPriorityHigh,
+		CreatedAt:   base,
+		QueuedAt:    base.Add(time.Second),
+		ExpiresAt:   base.Add(24 * time.Hour),
+		Files: []*model.File{
+			{
+				Status:      model.FileStatusCompleted,
+				URL:         "http://example.com/a.pdf",
+				ExpectedSHA: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+				Name:        "a.pdf",
+				Size:        1234,
+				SHA256:      "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+				ContentType: "application/pdf",
+				Attempts:    2,
+				Cached:      true,
+				StartedAt:   base.Add(2 * time.Second),
+				FinishedAt:  base.Add(3 * time.Second),
+			},
+			{
+				Status: model.FileStatusNotReachable,
+				URL:    "http://example.com/b.pdf",
+				Error:  "file not reachable: status 404 Not Found",
+			},
+		},
+	}
+
+	repo := openFileRepo(t, path)
+	if err := repo.Save(saved); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := repo.Save(&model.Task{ID: 8, Status: model.TaskStatusAccepted}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := repo.Delete(8, time.Now()); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	repo.Close()
+
+	reloaded := openFileRepo(t, path)
+	all, err := reloaded.GetAll()
+	if err != nil || len(all) != 1 {
+		t.Fatalf("GetAll = %d tasks, %v, want 1", len(all), err)
+	}
+
+	got, err := reloaded.GetByID(7)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if got.Status != saved.Status || got.Format != saved.Format || got.ArchiveName != saved.ArchiveName ||
+		got.ArchivePath != saved.ArchivePath || got.ArchiveURL != saved.ArchiveURL || got.Error != saved.Error ||
+		got.Owner != saved.Owner || got.Priority != saved.Priority || !got.CreatedAt.Equal(saved.CreatedAt) ||
+		!got.QueuedAt.Equal(saved.QueuedAt) || !got.ExpiresAt.Equal(saved.ExpiresAt) {
+		t.Errorf("reloaded task = %+v, want %+v", got, saved)
+	}
+	if len(got.Files) != len(saved.Files) {
+		t.Fatalf("reloaded %d files, want %d", len(got.Files), len(saved.Files))
+	}
+	for i, f := range got.Files {
+		want := saved.Files[i]
+		if f.Status != want.Status || f.URL != want.URL || f.ExpectedSHA != want.ExpectedSHA || f.Name != want.Name ||
+			f.Size != want.Size || f.SHA256 != want.SHA256 || f.ContentType != want.ContentType || f.Error != want.Error ||
+			f.Attempts != want.Attempts || f.Cached != want.Cached ||
+			!f.StartedAt.Equal(want.StartedAt) || !f.FinishedAt.Equal(want.FinishedAt) {
+			t.Errorf("reloaded file %d = %+v, want %+v", i, f, want)
+		}
+	}
+
+	// the list is served from the reloaded snapshots too
+	tasks, err := reloaded.List(Filter{Owner: "alice"}, Page{})
+	if err != nil || len(tasks) != 1 || tasks[0].ID != 7 {
+		t.Errorf("List after reload = %v, %v", tasks, err)
+	}
+}
+
+func TestFileTaskRepoReload(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantIDs []uint64
+		wantErr bool
+	}{
+		{"missing file", "", nil, false},
+		{"journal", `{"id":1,"task":{"ID":1,"Status":"accepted"}}` + "\n" +
+			`{"id":2,"task":{"ID":2,"Status":"queued"}}` + "\n" +
+			`{"id":1,"task":{"ID":1,"Status":"queued"}}` + "\n", []uint64{1, 2}, false},
+		{"deleted task", `{"id":1,"task":{"ID":1}}` + "\n" +
+			`{"id":2,"task":{"ID":2}}` + "\n" +
+			`{"id":1,"deleted":true}` + "\n", []uint64{2}, false},
+		{"torn last line", `{"id":1,"task":{"ID":1}}` + "\n" + `{"id":2,"task":{"I`, []uint64{1}, false},
+		{"broken line in the middle", `{"id":1,"task":{"ID":1}}` + "\n" + `garbage` + "\n" + `{"id":2,"task":{"ID":2}}` + "\n", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tasks.json")
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+					t.Fatalf("WriteFile: %v", err)
+				}
+			}
+
+			repo, err := NewFileTaskRepo(path)
+			if tt.wantErr {
+				if err == nil {
+					repo.Close()
+					t.Fatal("NewFileTaskRepo accepted a broken file")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewFileTaskRepo: %v", err)
+			}
+			defer repo.Close()
+
+			all, _ := repo.GetAll()
+			if len(all) != len(tt.wantIDs) {
+				t.Fatalf("loaded %d tasks, want %d", len(all), len(tt.wantIDs))
+			}
+			for _, id := range tt.wantIDs {
+				if _, err := repo.GetByID(id); err != nil {
+					t.Errorf("task %d not loaded", id)
+				}
+			}
+
+			// loading compacts the file to the last id and one line per task
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			if lines := strings.Count(string(data), "\n"); lines != len(tt.wantIDs)+1 {
+				t.Errorf("compacted file has %d lines, want %d", lines, len(tt.wantIDs)+1)
+			}
+		})
+	}
+}
+
+func TestFileTaskRepoCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	repo := openFileRepo(t, path)
+
+	task := &model.Task{ID: 1, Status: model.TaskStatusAccepted}
+	for i := 0; i < 500; i++ {
+		if err := repo.Save(task); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines > 2*2+compactSlack {
+		t.Errorf("journal has %d lines for one task, want it compacted", lines)
+	}
+
+	repo.Close()
+	if err := repo.Save(task); err == nil {
+		t.Error("Save after Close succeeded")
+	}
+}
+
+func TestFileTaskRepoKeepsLastIDAndTombstones(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	removedAt := base.Add(time.Hour)
+
+	repo := openFileRepo(t, path)
+	for id := uint64(1); id <= 3; id++ {
+		if err := repo.Save(&model.Task{ID: id}); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+	for id := uint64(2); id <= 3; id++ {
+		if err := repo.Delete(id, removedAt); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+	}
+	repo.Close()
+
+	// reopening compacts the journal, the deleted ids must survive it
+	for i := 0; i < 2; i++ {
+		repo = openFileRepo(t, path)
+		if got := repo.LastID(); got != 3 {
+			t.Errorf("LastID after reopen %d = %d, want 3", i, got)
+		}
+		if at, ok := repo.RemovedAt(3); !ok || !at.Equal(removedAt) {
+			t.Errorf("RemovedAt(3) after reopen %d = %v, %v, want %v", i, at, ok, removedAt)
+		}
+		if _, ok := repo.RemovedAt(1); ok {
+			t.Errorf("RemovedAt(1) after reopen %d reports a live task as removed", i)
+		}
+		repo.Close()
+	}
+
+	repo = openFileRepo(t, path)
+	if err := repo.ForgetRemoved(removedAt.Add(time.Second)); err != nil {
+		t.Fatalf("ForgetRemoved: %v", err)
+	}
+	if _, ok := repo.RemovedAt(2); ok {
+		t.Error("RemovedAt(2) after ForgetRemoved still reports the task")
+	}
+	repo.Close()
+
+	// the tombstones are gone, the last id is not
+	repo = openFileRepo(t, path)
+	if _, ok := repo.RemovedAt(2); ok {
+		t.Error("forgotten tombstone came back after reopen")
+	}
+	if got := repo.LastID(); got != 3 {
+		t.Errorf("LastID after forgetting = %d, want 3", got)
+	}
+}
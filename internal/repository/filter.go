@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"sort"
+	"time"
+
+	"github.com/folivorra/ziper/internal/model"
+)
+
+// Filter selects tasks for List and Count; zero fields match everything.
+// ExpiredBefore matches tasks whose expiry time is set and not after it.
+type Filter struct {
+	Statuses      []model.TaskStatus
+	CreatedAfter  time.Time
+	ExpiredBefore time.Time
+	Owner         string
+}
+
+// Page is a cursor page: tasks with ids above After, at most Limit of them
+// (no limit when zero), in id order.
+type Page struct {
+	After uint64
+	Limit int
+}
+
+func (f Filter) matches(t *model.Task) bool {
+	if f.Owner != "" && t.Owner != f.Owner {
+		return false
+	}
+
+	if !f.CreatedAfter.IsZero() && !t.CreatedAt.After(f.CreatedAfter) {
+		return false
+	}
+
+	if !f.ExpiredBefore.IsZero() && (t.ExpiresAt.IsZero() || t.ExpiresAt.After(f.ExpiredBefore)) {
+		return false
+	}
+
+	if len(f.Statuses) == 0 {
+		return true
+	}
+	for _, status := range f.Statuses {
+		if t.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// selectIDs returns the ids on the page in ascending order.
+func selectIDs(snapshots map[uint64]*model.Task, filter Filter, page Page) []uint64 {
+	ids := make([]uint64, 0)
+	for id, m := range snapshots {
+		if id > page.After && filter.matches(m) {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	if page.Limit > 0 && len(ids) > page.Limit {
+		ids = ids[:page.Limit]
+	}
+
+	return ids
+}
+
+// listSnapshots returns copies of the snapshots on the page, so callers can
+// keep them after the repository lock is released.
+func listSnapshots(snapshots map[uint64]*model.Task, filter Filter, page Page) []*model.Task {
+	ids := selectIDs(snapshots, filter, page)
+	tasks := make([]*model.Task, 0, len(ids))
+	for _, id := range ids {
+		tasks = append(tasks, snapshots[id].Clone())
+	}
+	return tasks
+}
+
+func countMatching(snapshots map[uint64]*model.Task, filter Filter) int {
+	count := 0
+	for _, m := range snapshots {
+		if filter.matches(m) {
+			count++
+		}
+	}
+	return count
+}
+
+// forgetRemoved drops the tombstones older than before and returns how
+// many were dropped.
+func forgetRemoved(removed map[uint64]time.Time, before time.Time) int {
+	dropped := 0
+	for id, at := range removed {
+		if at.Before(before) {
+			delete(removed, id)
+			dropped++
+		}
+	}
+	return dropped
+}
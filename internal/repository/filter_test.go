@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/folivorra/ziper/internal/model"
+)
+
+var base = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestFilterMatches(t *testing.T) {
+	task := &model.Task{
+		ID:        1,
+		Status:    model.TaskStatusQueued,
+		Owner:     "alice",
+		CreatedAt: base,
+		ExpiresAt: base.Add(time.Hour),
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		task   *model.Task
+		want   bool
+	}{
+		{"empty filter", Filter{}, task, true},
+		{"status", Filter{Statuses: []model.TaskStatus{model.TaskStatusQueued}}, task, true},
+		{"one of statuses", Filter{Statuses: []model.TaskStatus{model.TaskStatusCompleted, model.TaskStatusQueued}}, task, true},
+		{"other status", Filter{Statuses: []model.TaskStatus{model.TaskStatusCompleted}}, task, false},
+		{"owner", Filter{Owner: "alice"}, task, true},
+		{"other owner", Filter{Owner: "bob"}, task, false},
+		{"created after", Filter{CreatedAfter: base.Add(-time.Second)}, task, true},
+		{"created at the bound", Filter{CreatedAfter: base}, task, false},
+		{"created before", Filter{CreatedAfter: base.Add(time.Second)}, task, false},
+		{"expired", Filter{ExpiredBefore: base.Add(2 * time.Hour)}, task, true},
+		{"expires at the bound", Filter{ExpiredBefore: base.Add(time.Hour)}, task, true},
+		{"not expired yet", Filter{ExpiredBefore: base}, task, false},
+		{"never expires", Filter{ExpiredBefore: base.Add(2 * time.Hour)}, &model.Task{CreatedAt: base}, false},
+		{"all fields", Filter{
+			Statuses:     []model.TaskStatus{model.TaskStatusQueued},
+			Owner:        "alice",
+			CreatedAfter: base.Add(-time.Hour),
+		}, task, true},
+		{"all fields but one", Filter{
+			Statuses:     []model.TaskStatus{model.TaskStatusQueued},
+			Owner:        "bob",
+			CreatedAfter: base.Add(-time.Hour),
+		}, task, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.matches(tt.task); got != tt.want {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListPaging(t *testing.T) {
+	repo := NewInMemoryTaskRepo()
+	owners := []string{"alice", "bob", "alice", "alice", "bob", "alice", "alice"}
+	for i, owner := range owners {
+		err := repo.Save(&model.Task{
+			ID:        uint64(i + 1),
+			Status:    model.TaskStatusAccepted,
+			Owner:     owner,
+			CreatedAt: base.Add(time.Duration(i) * time.Minute),
+		})
+		if err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		limit  int
+		want   [][]uint64
+	}{
+		{"all in one page", Filter{}, 0, [][]uint64{{1, 2, 3, 4, 5, 6, 7}}},
+		{"pages of three", Filter{}, 3, [][]uint64{{1, 2, 3}, {4, 5, 6}, {7}}},
+		{"filtered pages", Filter{Owner: "alice"}, 2, [][]uint64{{1, 3}, {4, 6}, {7}}},
+		{"exact pages", Filter{Owner: "bob"}, 2, [][]uint64{{2, 5}}},
+		{"filter and date", Filter{Owner: "alice", CreatedAfter: base.Add(2 * time.Minute)}, 2, [][]uint64{{4, 6}, {7}}},
+		{"nothing matches", Filter{Owner: "carol"}, 2, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, err := repo.Count(tt.filter)
+			if err != nil {
+				t.Fatalf("Count: %v", err)
+			}
+			wantCount := 0
+			for _, page := range tt.want {
+				wantCount += len(page)
+			}
+			if count != wantCount {
+				t.Errorf("Count = %d, want %d", count, wantCount)
+			}
+
+			// follow the cursor the way a client does: the next page
+			// starts after the last id of the previous one
+			var got [][]uint64
+			page := Page{Limit: tt.limit}
+			for {
+				tasks, err := repo.List(tt.filter, page)
+				if err != nil {
+					t.Fatalf("List: %v", err)
+				}
+				if len(tasks) == 0 {
+					break
+				}
+
+				ids := make([]uint64, 0, len(tasks))
+				for _, task := range tasks {
+					ids = append(ids, task.ID)
+				}
+				got = append(got, ids)
+				page.After = ids[len(ids)-1]
+
+				if len(got) > len(owners) {
+					t.Fatal("paging doesn't end")
+				}
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("pages = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if len(got[i]) != len(tt.want[i]) {
+					t.Fatalf("pages = %v, want %v", got, tt.want)
+				}
+				for j := range got[i] {
+					if got[i][j] != tt.want[i][j] {
+						t.Fatalf("pages = %v, want %v", got, tt.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestListReturnsSnapshots(t *testing.T) {
+	repo := NewInMemoryTaskRepo()
+	task := &model.Task{ID: 1, Status: model.TaskStatusAccepted, Files: []*model.File{{URL: "http://example.com/a.pdf"}}}
+	if err := repo.Save(task); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	// changes not saved yet must not show up in the list
+	task.Status = model.TaskStatusQueued
+	task.Files[0].Status = model.FileStatusCompleted
+
+	tasks, err := repo.List(Filter{}, Page{})
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("List = %v, %v", tasks, err)
+	}
+	if tasks[0].Status != model.TaskStatusAccepted || tasks[0].Files[0].Status != "" {
+		t.Errorf("List returned unsaved changes: %+v", tasks[0])
+	}
+	if tasks[0] == task {
+		t.Error("List returned the live task")
+	}
+}
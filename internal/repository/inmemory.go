@@ -3,27 +3,36 @@ package repository
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/folivorra/ziper/internal/model"
 )
 
 type InMemoryTaskRepo struct {
-	mu    sync.RWMutex
-	tasks map[uint64]*model.Task
+	mu        sync.RWMutex
+	tasks     map[uint64]*model.Task
+	snapshots map[uint64]*model.Task
+	removed   map[uint64]time.Time
+	lastID    uint64
 }
 
 var _ TaskRepo = (*InMemoryTaskRepo)(nil)
 
 func NewInMemoryTaskRepo() *InMemoryTaskRepo {
 	return &InMemoryTaskRepo{
-		tasks: make(map[uint64]*model.Task),
+		tasks:     make(map[uint64]*model.Task),
+		snapshots: make(map[uint64]*model.Task),
+		removed:   make(map[uint64]time.Time),
 	}
 }
 
-func (t *InMemoryTaskRepo) Save(task *model.Task) {
+func (t *InMemoryTaskRepo) Save(task *model.Task) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.tasks[task.ID] = task
+	t.snapshots[task.ID] = task.Clone()
+	t.lastID = max(t.lastID, task.ID)
+	return nil
 }
 
 func (t *InMemoryTaskRepo) GetByID(id uint64) (*model.Task, error) {
@@ -38,3 +47,66 @@ func (t *InMemoryTaskRepo) GetByID(id uint64) (*model.Task, error) {
 
 	return task, nil
 }
+
+func (t *InMemoryTaskRepo) GetAll() ([]*model.Task, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	tasks := make([]*model.Task, 0, len(t.tasks))
+	for _, task := range t.tasks {
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
+
+func (t *InMemoryTaskRepo) Delete(id uint64, removedAt time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.tasks[id]; !ok {
+		return nil
+	}
+
+	delete(t.tasks, id)
+	delete(t.snapshots, id)
+	t.removed[id] = removedAt
+	return nil
+}
+
+func (t *InMemoryTaskRepo) RemovedAt(id uint64) (time.Time, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	at, ok := t.removed[id]
+	return at, ok
+}
+
+func (t *InMemoryTaskRepo) ForgetRemoved(before time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	forgetRemoved(t.removed, before)
+	return nil
+}
+
+func (t *InMemoryTaskRepo) LastID() uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.lastID
+}
+
+func (t *InMemoryTaskRepo) List(filter Filter, page Page) ([]*model.Task, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return listSnapshots(t.snapshots, filter, page), nil
+}
+
+func (t *InMemoryTaskRepo) Count(filter Filter) (int, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return countMatching(t.snapshots, filter), nil
+}
@@ -1,8 +1,30 @@
 package repository
 
-import "github.com/folivorra/ziper/internal/model"
+import (
+	"time"
+
+	"github.com/folivorra/ziper/internal/model"
+)
 
 type TaskRepo interface {
-	Save(task *model.Task)
+	Save(task *model.Task) error
 	GetByID(id uint64) (*model.Task, error)
+	GetAll() ([]*model.Task, error)
+	// List returns copies of the tasks as of their last Save. The snapshot
+	// is taken while the caller holds the task lock, so List and Count
+	// never need it.
+	List(filter Filter, page Page) ([]*model.Task, error)
+	Count(filter Filter) (int, error)
+	// Delete removes the task and leaves a tombstone with the removal time,
+	// so the id is still known to be gone.
+	Delete(id uint64, removedAt time.Time) error
+	// RemovedAt reports when the task was deleted while its tombstone is
+	// kept.
+	RemovedAt(id uint64) (time.Time, bool)
+	// ForgetRemoved drops the tombstones of tasks deleted before the given
+	// time.
+	ForgetRemoved(before time.Time) error
+	// LastID is the highest id ever saved, deleted tasks included, so ids
+	// are never handed out twice.
+	LastID() uint64
 }
@@ -2,17 +2,26 @@ package rest
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"mime"
 	"net/http"
 	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/folivorra/ziper/internal/model"
 	"github.com/folivorra/ziper/internal/usecase"
 	"github.com/gorilla/mux"
 )
 
+// clientIDHeader identifies the client that creates a task; it becomes the
+// task owner.
+const clientIDHeader = "X-Client-ID"
+
 type Controller struct {
 	taskService *usecase.TaskService
 	logger      *slog.Logger
@@ -25,10 +34,49 @@ func NewController(taskService *usecase.TaskService, logger *slog.Logger) *Contr
 	}
 }
 
-func (c *Controller) CreateTaskHandler(w http.ResponseWriter, _ *http.Request) {
-	id, err := c.taskService.CreateTask()
+// CreateTaskHandler creates a task. The body is optional; when it lists
+// files they are added at once and the task is submitted unless "submit"
+// is false.
+func (c *Controller) CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
+	request := struct {
+		Format   model.ArchiveFormat `json:"format"`
+		Name     string              `json:"name"`
+		Priority model.TaskPriority  `json:"priority"`
+		Files    []struct {
+			URL    string `json:"url"`
+			SHA256 string `json:"sha256"`
+			Name   string `json:"name"`
+		} `json:"files"`
+		Submit *bool `json:"submit"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := usecase.TaskOptions{
+		Format:   request.Format,
+		Name:     request.Name,
+		Owner:    r.Header.Get(clientIDHeader),
+		Priority: request.Priority,
+		Files:    make([]usecase.FileRequest, 0, len(request.Files)),
+		Submit:   request.Submit == nil || *request.Submit,
+	}
+	for _, f := range request.Files {
+		opts.Files = append(opts.Files, usecase.FileRequest{URL: f.URL, SHA256: f.SHA256, Name: f.Name})
+	}
+
+	created, err := c.taskService.CreateTask(opts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		switch {
+		case errors.Is(err, usecase.ErrUnsupportedFormat) || errors.Is(err, usecase.ErrInvalidPriority):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, usecase.ErrNoFilesAccepted):
+			writeFilesRejected(w, created.Files, err)
+		default:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		}
 		return
 	}
 
@@ -36,9 +84,17 @@ func (c *Controller) CreateTaskHandler(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 
 	response := struct {
-		ID uint64 `json:"id"`
+		ID            uint64               `json:"id"`
+		Status        model.TaskStatus     `json:"status"`
+		SubmitPending bool                 `json:"submit_pending,omitempty"`
+		Files         []fileStatusResponse `json:"files,omitempty"`
+		Error         string               `json:"error,omitempty"`
 	}{
-		ID: id,
+		ID:            created.ID,
+		Status:        created.Status,
+		SubmitPending: created.SubmitPending,
+		Files:         newFileStatusResponses(created.Files),
+		Error:         created.Error,
 	}
 
 	err = json.NewEncoder(w).Encode(response)
@@ -47,6 +103,103 @@ func (c *Controller) CreateTaskHandler(w http.ResponseWriter, _ *http.Request) {
 	}
 }
 
+// ListTasksHandler serves GET /tasks?status=queued,in_progress&owner=...
+// &created_after=<RFC 3339>&limit=50&cursor=<next_cursor>.
+func (c *Controller) ListTasksHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := usecase.ListQuery{Owner: q.Get("owner")}
+
+	for _, value := range q["status"] {
+		for _, status := range strings.Split(value, ",") {
+			if status != "" {
+				query.Statuses = append(query.Statuses, model.TaskStatus(status))
+			}
+		}
+	}
+
+	if value := q.Get("created_after"); value != "" {
+		createdAfter, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid created_after: %s", err), http.StatusBadRequest)
+			return
+		}
+		query.CreatedAfter = createdAfter
+	}
+
+	if value := q.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", value), http.StatusBadRequest)
+			return
+		}
+		query.Limit = limit
+	}
+
+	if value := q.Get("cursor"); value != "" {
+		after, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid cursor %q", value), http.StatusBadRequest)
+			return
+		}
+		query.After = after
+	}
+
+	list, err := c.taskService.ListTasks(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type taskResponse struct {
+		ID        uint64              `json:"id"`
+		Status    model.TaskStatus    `json:"status"`
+		Format    model.ArchiveFormat `json:"format,omitempty"`
+		Name      string              `json:"name,omitempty"`
+		Owner     string              `json:"owner,omitempty"`
+		Priority  model.TaskPriority  `json:"priority,omitempty"`
+		CreatedAt *time.Time          `json:"created_at,omitempty"`
+		Files     int                 `json:"files"`
+		URL       string              `json:"path,omitempty"`
+		Error     string              `json:"error,omitempty"`
+	}
+
+	tasks := make([]taskResponse, 0, len(list.Tasks))
+	for _, task := range list.Tasks {
+		tr := taskResponse{
+			ID:       task.ID,
+			Status:   task.Status,
+			Format:   task.Format,
+			Name:     task.ArchiveName,
+			Owner:    task.Owner,
+			Priority: task.Priority,
+			Files:    len(task.Files),
+			URL:      task.ArchiveURL,
+			Error:    task.Error,
+		}
+		if !task.CreatedAt.IsZero() {
+			tr.CreatedAt = &task.CreatedAt
+		}
+		tasks = append(tasks, tr)
+	}
+
+	response := struct {
+		Tasks      []taskResponse `json:"tasks"`
+		Total      int            `json:"total"`
+		NextCursor string         `json:"next_cursor,omitempty"`
+	}{
+		Tasks: tasks,
+		Total: list.Total,
+	}
+	if list.Next != 0 {
+		response.NextCursor = strconv.FormatUint(list.Next, 10)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func (c *Controller) AddFileByIDHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.ParseUint(vars["id"], 10, 64)
@@ -56,7 +209,9 @@ func (c *Controller) AddFileByIDHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	request := struct {
-		URL string `json:"url"`
+		URL    string `json:"url"`
+		SHA256 string `json:"sha256"`
+		Name   string `json:"name"`
 	}{}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -64,7 +219,11 @@ func (c *Controller) AddFileByIDHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	status, err := c.taskService.AddFileByID(id, request.URL)
+	status, err := c.taskService.AddFileByID(id, request.URL, request.SHA256, request.Name)
+	if err != nil && status == model.FileStatusFailed {
+		writeAddFilesError(w, err)
+		return
+	}
 
 	response := struct {
 		FileStatus model.FileStatus `json:"status"`
@@ -72,17 +231,94 @@ func (c *Controller) AddFileByIDHandler(w http.ResponseWriter, r *http.Request)
 		FileStatus: status,
 	}
 
-	if err != nil && response.FileStatus == model.FileStatusFailed {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// AddFilesHandler adds several files at once and reports a status for each
+// of them in request order.
+func (c *Controller) AddFilesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	request := struct {
+		Files []struct {
+			URL    string `json:"url"`
+			SHA256 string `json:"sha256"`
+			Name   string `json:"name"`
+		} `json:"files"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	reqs := make([]usecase.FileRequest, 0, len(request.Files))
+	for _, f := range request.Files {
+		reqs = append(reqs, usecase.FileRequest{URL: f.URL, SHA256: f.SHA256, Name: f.Name})
+	}
+
+	results, err := c.taskService.AddFiles(id, reqs)
+	if err != nil {
+		writeAddFilesError(w, err)
+		return
+	}
+
+	response := struct {
+		Files []fileStatusResponse `json:"files"`
+	}{
+		Files: newFileStatusResponses(results),
+	}
+
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-func (c *Controller) GetTaskStatusAndArchivePathHandler(w http.ResponseWriter, r *http.Request) {
+// writeFilesRejected answers 422 with the reasons the files were rejected
+// for when a task was not created.
+func writeFilesRejected(w http.ResponseWriter, results []usecase.FileResult, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+
+	response := struct {
+		Files []fileStatusResponse `json:"files"`
+		Error string               `json:"error"`
+	}{
+		Files: newFileStatusResponses(results),
+		Error: err.Error(),
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type fileStatusResponse struct {
+	URL    string           `json:"url"`
+	Status model.FileStatus `json:"status"`
+	Error  string           `json:"error,omitempty"`
+}
+
+func newFileStatusResponses(results []usecase.FileResult) []fileStatusResponse {
+	files := make([]fileStatusResponse, 0, len(results))
+	for _, res := range results {
+		fr := fileStatusResponse{URL: res.URL, Status: res.Status}
+		if res.Err != nil {
+			fr.Error = res.Err.Error()
+		}
+		files = append(files, fr)
+	}
+	return files
+}
+
+func (c *Controller) SubmitTaskHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.ParseUint(vars["id"], 10, 64)
 	if err != nil {
@@ -90,18 +326,163 @@ func (c *Controller) GetTaskStatusAndArchivePathHandler(w http.ResponseWriter, r
 		return
 	}
 
-	status, url, err := c.taskService.GetTaskStatusAndArchiveURL(id)
+	status, err := c.taskService.SubmitTask(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		switch {
+		case errors.Is(err, usecase.ErrTaskNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, usecase.ErrTaskBusy):
+			writeBusy(w, err)
+		case errors.Is(err, usecase.ErrTaskAlreadySubmitted):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	response := struct {
+		Status        model.TaskStatus `json:"status"`
+		SubmitPending bool             `json:"submit_pending,omitempty"`
+	}{
+		Status:        status,
+		SubmitPending: status == model.TaskStatusAccepted,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (c *Controller) CancelTaskHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status, err := c.taskService.CancelTask(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrTaskNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, usecase.ErrTaskBusy):
+			writeBusy(w, err)
+		case errors.Is(err, usecase.ErrTaskNotCancellable):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
 	response := struct {
 		Status model.TaskStatus `json:"status"`
-		URL    string           `json:"path,omitempty"`
 	}{
 		Status: status,
-		URL:    url,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// a running task stops in the background
+	if status != model.TaskStatusCancelled {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (c *Controller) GetTaskStatusAndArchivePathHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	task, err := c.taskService.GetTask(id)
+	if err != nil {
+		if errors.Is(err, usecase.ErrTaskExpired) {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		if errors.Is(err, usecase.ErrTaskBusy) {
+			writeBusy(w, err)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	type fileResponse struct {
+		URL         string           `json:"url"`
+		Status      model.FileStatus `json:"status"`
+		Name        string           `json:"name,omitempty"`
+		Size        int64            `json:"size,omitempty"`
+		SHA256      string           `json:"sha256,omitempty"`
+		ContentType string           `json:"content_type,omitempty"`
+		Error       string           `json:"error,omitempty"`
+		Attempts    int              `json:"attempts,omitempty"`
+		Cached      bool             `json:"cached,omitempty"`
+		StartedAt   *time.Time       `json:"started_at,omitempty"`
+		FinishedAt  *time.Time       `json:"finished_at,omitempty"`
+		DurationMs  int64            `json:"duration_ms,omitempty"`
+	}
+
+	files := make([]fileResponse, 0, len(task.Files))
+	for _, f := range task.Files {
+		fr := fileResponse{
+			URL:         f.URL,
+			Status:      f.Status,
+			Name:        f.Name,
+			Size:        f.Size,
+			SHA256:      f.SHA256,
+			ContentType: f.ContentType,
+			Error:       f.Error,
+			Attempts:    f.Attempts,
+			Cached:      f.Cached,
+		}
+		if !f.StartedAt.IsZero() {
+			fr.StartedAt = &f.StartedAt
+		}
+		if !f.FinishedAt.IsZero() {
+			fr.FinishedAt = &f.FinishedAt
+			fr.DurationMs = f.FinishedAt.Sub(f.StartedAt).Milliseconds()
+		}
+		files = append(files, fr)
+	}
+
+	response := struct {
+		Status        model.TaskStatus    `json:"status"`
+		Format        model.ArchiveFormat `json:"format,omitempty"`
+		Name          string              `json:"name,omitempty"`
+		URL           string              `json:"path,omitempty"`
+		Error         string              `json:"error,omitempty"`
+		SubmitPending bool                `json:"submit_pending,omitempty"`
+		Priority      model.TaskPriority  `json:"priority,omitempty"`
+		QueuePosition *int                `json:"queue_position,omitempty"`
+		ExpiresAt     *time.Time          `json:"expires_at,omitempty"`
+		Files         []fileResponse      `json:"files"`
+	}{
+		Status:        task.Status,
+		Format:        task.Format,
+		Name:          task.ArchiveName,
+		URL:           task.ArchiveURL,
+		Error:         task.Error,
+		SubmitPending: task.SubmitPending,
+		Priority:      task.Priority,
+		Files:         files,
+	}
+	// position 1 is the task the next free worker takes
+	if ahead, ok := c.taskService.QueuePosition(id); ok && task.Status == model.TaskStatusQueued {
+		position := ahead + 1
+		response.QueuePosition = &position
+	}
+	if !task.ExpiresAt.IsZero() {
+		response.ExpiresAt = &task.ExpiresAt
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -114,34 +495,121 @@ func (c *Controller) DownloadArchiveHandler(w http.ResponseWriter, r *http.Reque
 	vars := mux.Vars(r)
 	filename := vars["filename"]
 
-	idStr := filename[len("task-") : len(filename)-len(".zip")]
+	idStr, _, _ := strings.Cut(strings.TrimPrefix(filename, "task-"), ".")
 	id, err := strconv.ParseUint(idStr, 10, 64)
-	if err != nil {
+	if err != nil || !strings.HasPrefix(filename, "task-") {
 		http.Error(w, "invalid archive filename", http.StatusBadRequest)
 		return
 	}
 
-	taskStatus, _, err := c.taskService.GetTaskStatusAndArchiveURL(id)
+	task, err := c.taskService.GetTask(id)
 	if err != nil {
+		if errors.Is(err, usecase.ErrTaskExpired) {
+			http.Error(w, "archive expired", http.StatusGone)
+			return
+		}
+		if errors.Is(err, usecase.ErrTaskBusy) {
+			writeBusy(w, err)
+			return
+		}
 		http.Error(w, "failed to get archive path", http.StatusNotFound)
 		return
 	}
 
-	if taskStatus == model.TaskStatusInProgress {
+	switch task.Status {
+	case model.TaskStatusQueued, model.TaskStatusInProgress:
 		http.Error(w, "archive still in progress", http.StatusAccepted)
 		return
+	case model.TaskStatusAccepted:
+		http.Error(w, "task is not submitted yet", http.StatusConflict)
+		return
+	case model.TaskStatusCancelled:
+		http.Error(w, "task was cancelled", http.StatusConflict)
+		return
+	case model.TaskStatusFailed:
+		http.Error(w, fmt.Sprintf("task failed: %s", task.Error), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if filename != path.Base(task.ArchivePath) {
+		http.Error(w, "archive not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", c.taskService.ArchiveContentType(task.Format))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": c.taskService.ArchiveFileName(task),
+	}))
+
+	if !c.taskService.IsStreamingArchives() {
+		http.ServeFile(w, r, task.ArchivePath)
+		return
+	}
+
+	// the archive size is unknown in advance, so the server write timeout
+	// would cut off big streams
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		c.logger.Warn("failed to reset write deadline",
+			slog.String("error", err.Error()),
+		)
+	}
+
+	if err := c.taskService.StreamArchive(r.Context(), id, w); err != nil {
+		if errors.Is(err, usecase.ErrArchiveNotReady) {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, usecase.ErrTaskBusy) {
+			w.Header().Del("Content-Disposition")
+			writeBusy(w, err)
+			return
+		}
+		c.logger.Error("archive stream interrupted",
+			slog.Uint64("task_id", id),
+			slog.String("error", err.Error()),
+		)
 	}
+}
+
+// LockStatsHandler reports how much requests wait for task locks.
+func (c *Controller) LockStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.taskService.LockStats()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
 
-	filePath := path.Join("archives", filename)
+// writeAddFilesError answers both add endpoints the same way; a rejected
+// file on its own is a bad request.
+func writeAddFilesError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrTaskNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, usecase.ErrTaskBusy):
+		writeBusy(w, err)
+	case errors.Is(err, usecase.ErrTaskAlreadySubmitted):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
 
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"task-%d.zip\"", id))
-	http.ServeFile(w, r, filePath)
+// writeBusy answers a request that gave up waiting for the task lock.
+func writeBusy(w http.ResponseWriter, err error) {
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
 }
 
 func (c *Controller) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/tasks", c.CreateTaskHandler).Methods("POST")
+	r.HandleFunc("/tasks", c.ListTasksHandler).Methods("GET")
 	r.HandleFunc("/tasks/{id}", c.GetTaskStatusAndArchivePathHandler).Methods("GET")
 	r.HandleFunc("/tasks/{id}/add", c.AddFileByIDHandler).Methods("POST")
+	r.HandleFunc("/tasks/{id}/files", c.AddFilesHandler).Methods("POST")
+	r.HandleFunc("/tasks/{id}/submit", c.SubmitTaskHandler).Methods("POST")
+	r.HandleFunc("/tasks/{id}", c.CancelTaskHandler).Methods("DELETE")
+	r.HandleFunc("/tasks/{id}/cancel", c.CancelTaskHandler).Methods("POST")
 	r.HandleFunc("/archives/{filename:.+}", c.DownloadArchiveHandler).Methods("GET")
+	r.HandleFunc("/debug/locks", c.LockStatsHandler).Methods("GET")
 }
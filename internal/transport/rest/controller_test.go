@@ -0,0 +1,108 @@
+package rest
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/folivorra/ziper/app"
+	"github.com/folivorra/ziper/internal/adapter/archiver"
+	"github.com/folivorra/ziper/internal/config"
+	"github.com/folivorra/ziper/internal/model"
+	"github.com/folivorra/ziper/internal/repository"
+	"github.com/folivorra/ziper/internal/usecase"
+	"github.com/gorilla/mux"
+)
+
+func TestDownloadArchiveHandlerStatus(t *testing.T) {
+	// a finished task leaves status empty: it is worked out from the files
+	// and the archive outcome the way ProcessTask does
+	tests := []struct {
+		name     string
+		status   model.TaskStatus
+		files    []model.FileStatus
+		archived bool
+		expired  bool
+		removed  bool
+		want     int
+	}{
+		{name: "completed", files: []model.FileStatus{model.FileStatusCompleted}, archived: true, want: http.StatusOK},
+		{name: "partially completed", files: []model.FileStatus{model.FileStatusCompleted, model.FileStatusNotReachable}, archived: true, want: http.StatusOK},
+		{name: "failed, no files downloaded", files: []model.FileStatus{model.FileStatusFailed}, archived: true, want: http.StatusUnprocessableEntity},
+		{name: "failed, archive error", files: []model.FileStatus{model.FileStatusCompleted}, archived: false, want: http.StatusUnprocessableEntity},
+		{name: "files rejected at validation", status: model.TaskStatusAccepted, files: []model.FileStatus{model.FileStatusTooLarge}, want: http.StatusConflict},
+		{name: "cancelled", status: model.TaskStatusCancelled, files: []model.FileStatus{model.FileStatusAccepted}, want: http.StatusConflict},
+		{name: "in progress", status: model.TaskStatusInProgress, files: []model.FileStatus{model.FileStatusAccepted}, want: http.StatusAccepted},
+		{name: "expired", files: []model.FileStatus{model.FileStatusCompleted}, archived: true, expired: true, want: http.StatusGone},
+		{name: "removed", files: []model.FileStatus{model.FileStatusCompleted}, archived: true, removed: true, want: http.StatusGone},
+	}
+
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := repository.NewInMemoryTaskRepo()
+
+	paths := make([]string, len(tests))
+	for i, tt := range tests {
+		task := &model.Task{
+			ID:          uint64(i + 1),
+			Status:      tt.status,
+			Format:      model.ArchiveFormatZip,
+			ArchivePath: filepath.Join(dir, fmt.Sprintf("task-%d.zip", i+1)),
+		}
+		for _, status := range tt.files {
+			task.Files = append(task.Files, &model.File{Status: status})
+		}
+		if task.Status == "" {
+			task.Status = usecase.TaskResultStatus(task.Files, tt.archived)
+		}
+		if tt.expired {
+			task.ExpiresAt = time.Now().Add(-time.Minute)
+		}
+		paths[i] = task.ArchivePath
+
+		if usecase.HasArchiveURL(task.Status) {
+			if err := os.WriteFile(task.ArchivePath, []byte("PK"), 0644); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+		}
+		if err := repo.Save(task); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		if tt.removed {
+			if err := repo.Delete(task.ID, time.Now()); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+		}
+	}
+
+	cfg := config.Config{LockTimeout: time.Second, TaskTTL: time.Hour}
+	archivers := map[model.ArchiveFormat]archiver.Archiver{
+		model.ArchiveFormatZip: archiver.NewZipArchiver(app.NewApp(logger), dir, logger, true, archiver.CompressionDeflate, -1),
+	}
+	service := usecase.NewTaskService(repo, cfg, logger, usecase.NewLockTaskManager(), nil, nil, archivers, nil, usecase.NewPriorityQueue())
+
+	router := mux.NewRouter()
+	NewController(service, logger).RegisterRoutes(router)
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/archives/"+filepath.Base(paths[i]), nil))
+			if rec.Code != tt.want {
+				t.Errorf("GET archive = %d %q, want %d", rec.Code, rec.Body.String(), tt.want)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/archives/task-99.zip", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET archive of an unknown task = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,9 @@
+package validation
+
+import "errors"
+
+var (
+	ErrNotReachable     = errors.New("file not reachable")
+	ErrNotSupportedType = errors.New("not supported file type")
+	ErrTooLarge         = errors.New("file too large")
+)
@@ -1,30 +1,65 @@
 package validation
 
 import (
+	"fmt"
 	"net/http"
 	"time"
+
+	"github.com/folivorra/ziper/internal/mimetype"
 )
 
 type HTTPValidator struct {
-	client *http.Client
+	client      *http.Client
+	allowed     mimetype.AllowList
+	maxFileSize int64
 }
 
 var _ FileValidator = (*HTTPValidator)(nil)
 
-func NewHTTPValidator(timeout time.Duration) *HTTPValidator {
+func NewHTTPValidator(
+	timeout time.Duration,
+	transport http.RoundTripper,
+	allowed mimetype.AllowList,
+	maxFileSize int64,
+) *HTTPValidator {
 	return &HTTPValidator{
 		client: &http.Client{
-			Timeout: timeout,
+			Timeout:   timeout,
+			Transport: transport,
 		},
+		allowed:     allowed,
+		maxFileSize: maxFileSize,
 	}
 }
 
-func (v *HTTPValidator) IsReachable(url string) bool {
+// Validate sends a HEAD request and checks the reported Content-Type and
+// Content-Length. Missing values pass here and are settled during the
+// download by sniffing the body and counting the bytes.
+func (v *HTTPValidator) Validate(url string) (*Info, error) {
 	resp, err := v.client.Head(url)
 	if err != nil {
-		return false
+		return nil, fmt.Errorf("%w: %w", ErrNotReachable, err)
 	}
 	defer resp.Body.Close()
 
-	return resp.StatusCode >= 200 && resp.StatusCode < 300
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%w: status %s", ErrNotReachable, resp.Status)
+	}
+
+	info := &Info{ContentLength: resp.ContentLength}
+
+	if v.maxFileSize > 0 && info.ContentLength > v.maxFileSize {
+		return info, fmt.Errorf("%w: %d bytes, limit %d", ErrTooLarge, info.ContentLength, v.maxFileSize)
+	}
+
+	contentType := mimetype.Normalize(resp.Header.Get("Content-Type"))
+	if contentType == "" || contentType == mimetype.Unknown {
+		return info, nil
+	}
+
+	if !v.allowed.Allows(contentType) {
+		return info, fmt.Errorf("%w %s", ErrNotSupportedType, contentType)
+	}
+
+	return info, nil
 }
@@ -1,5 +1,11 @@
 package validation
 
+// Info is what the HEAD request told about the file; ContentLength is -1
+// when the server didn't report it.
+type Info struct {
+	ContentLength int64
+}
+
 type FileValidator interface {
-	IsReachable(url string) bool
+	Validate(url string) (*Info, error)
 }
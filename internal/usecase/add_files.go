@@ -0,0 +1,231 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	net "net/url"
+	"strings"
+
+	"github.com/folivorra/ziper/internal/model"
+	"github.com/folivorra/ziper/internal/transport/validation"
+)
+
+// FileRequest is a file to add: the URL plus the optional expected
+// checksum and name in the archive.
+type FileRequest struct {
+	URL    string
+	SHA256 string
+	Name   string
+}
+
+type FileResult struct {
+	URL    string
+	Status model.FileStatus
+	Err    error
+}
+
+// AddFiles adds several files in one go. The task lock is only held to
+// reserve the slots: the files are added as validating and their URLs are
+// checked in the background, so the status is to be polled with
+// GET /tasks/{id}. Files that don't fit under MaxFilesInTask are rejected
+// right away. Results follow the order of reqs.
+func (s *TaskService) AddFiles(id uint64, reqs []FileRequest) ([]FileResult, error) {
+	s.logger.Info("adding files to task",
+		slog.Uint64("id", id),
+		slog.Int("count", len(reqs)),
+	)
+
+	if len(reqs) == 0 {
+		return nil, ErrNoFilesGiven
+	}
+
+	task, err := s.repo.GetByID(id)
+	if err != nil {
+		s.logger.Error("error getting task by id",
+			slog.Uint64("id", id),
+			slog.String("error", err.Error()),
+		)
+		return nil, fmt.Errorf("%w by id %d", ErrTaskNotFound, id)
+	}
+
+	unlock, err := s.lockTask(id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if task.Status != model.TaskStatusAccepted || task.SubmitPending {
+		s.logger.Error("task already submitted",
+			slog.Uint64("id", id),
+			slog.String("status", string(task.Status)),
+		)
+		return nil, fmt.Errorf("%w with status %s", ErrTaskAlreadySubmitted, task.Status)
+	}
+
+	results, added := s.addFiles(task, reqs)
+	if added == 0 {
+		return results, nil
+	}
+
+	if s.cfg.AutoSubmit && len(task.Files) == int(s.cfg.MaxFilesInTask) && s.submit(task) == nil {
+		return results, nil
+	}
+	s.saveTask(task)
+
+	return results, nil
+}
+
+// checkFiles runs the checks that need no request, for a task holding
+// taken files. Files with a malformed checksum or over MaxFilesInTask get
+// no slot; a file with a malformed URL takes its slot as invalid_url and
+// the rest take theirs as validating.
+func (s *TaskService) checkFiles(reqs []FileRequest, taken int) ([]FileResult, []int) {
+	results := make([]FileResult, len(reqs))
+
+	free := 0
+	if uint64(taken) < s.cfg.MaxFilesInTask {
+		free = int(s.cfg.MaxFilesInTask) - taken
+	}
+
+	// slots go to the requests in order, so which ones are rejected doesn't
+	// depend on validation timing
+	slots := make([]int, 0, min(free, len(reqs)))
+	for i, req := range reqs {
+		results[i].URL = req.URL
+
+		switch {
+		case !IsValidSHA256(req.SHA256):
+			results[i].Status = model.FileStatusFailed
+			results[i].Err = fmt.Errorf("%w %q", ErrInvalidChecksum, req.SHA256)
+			continue
+		case len(slots) >= free:
+			results[i].Status = model.FileStatusFailed
+			results[i].Err = fmt.Errorf("task exceeds max files %d", s.cfg.MaxFilesInTask)
+			continue
+		}
+
+		slots = append(slots, i)
+		if _, err := net.ParseRequestURI(req.URL); err != nil {
+			results[i].Status = model.FileStatusInvalidURL
+			results[i].Err = fmt.Errorf("invalid url %s", req.URL)
+		} else {
+			results[i].Status = model.FileStatusValidating
+		}
+	}
+
+	return results, slots
+}
+
+// addFiles reserves slots for the requested files and reports how many
+// were appended; the caller must hold the task lock. The URL checks start
+// at once but wait for the lock to record their outcome.
+func (s *TaskService) addFiles(task *model.Task, reqs []FileRequest) ([]FileResult, int) {
+	results, slots := s.checkFiles(reqs, len(task.Files))
+
+	if rejected := len(reqs) - len(slots); rejected > 0 {
+		s.logger.Warn("files rejected",
+			slog.Uint64("task_id", task.ID),
+			slog.Uint64("maxFilesInTask", s.cfg.MaxFilesInTask),
+			slog.Uint64("currentFiles", uint64(len(task.Files))),
+			slog.Int("rejected", rejected),
+		)
+	}
+
+	for _, i := range slots {
+		req := reqs[i]
+		file := &model.File{
+			Status:       model.FileStatusValidating,
+			URL:          req.URL,
+			ExpectedSize: -1,
+			ExpectedSHA:  strings.ToLower(req.SHA256),
+			ClientName:   req.Name,
+		}
+
+		if results[i].Status == model.FileStatusInvalidURL {
+			s.logger.Warn("invalid url",
+				slog.String("url", req.URL),
+			)
+			file.Status = model.FileStatusInvalidURL
+			file.Error = results[i].Err.Error()
+		} else {
+			go s.validateFile(task, file)
+		}
+
+		task.Files = append(task.Files, file)
+
+		s.logger.Info("added file to task",
+			slog.Uint64("task_id", task.ID),
+			slog.String("file_status", string(file.Status)),
+			slog.String("file_url", file.URL),
+		)
+	}
+
+	return results, len(slots)
+}
+
+// validateFile checks the URL of a validating file without the task lock
+// and records the outcome under it. A task submitted in the meantime goes
+// to the queue once its last file is checked.
+func (s *TaskService) validateFile(task *model.Task, file *model.File) {
+	info, err := s.validr.Validate(file.URL)
+	if err != nil {
+		s.logger.Warn("file validation failed",
+			slog.String("url", file.URL),
+			slog.String("error", err.Error()),
+		)
+	}
+
+	// the outcome must not be lost, so there is no timeout here; the lock
+	// is only ever held briefly while the task is accepted
+	unlock, _ := s.lockManager.Lock(context.Background(), task.ID)
+	defer unlock()
+
+	if task.Status != model.TaskStatusAccepted || file.Status != model.FileStatusValidating {
+		return
+	}
+
+	s.applyValidation(task, file, info, err)
+
+	s.logger.Info("file validated",
+		slog.Uint64("task_id", task.ID),
+		slog.String("file_status", string(file.Status)),
+		slog.String("file_url", file.URL),
+	)
+
+	if task.SubmitPending && !HasValidatingFiles(task.Files) {
+		if HasPendingFiles(task.Files) {
+			s.enqueue(task)
+			return
+		}
+
+		// the client has to add other files and submit the task again
+		task.SubmitPending = false
+		task.Error = "no file passed validation, task was not submitted"
+		s.logger.Warn("task not submitted, all files rejected",
+			slog.Uint64("task_id", task.ID),
+		)
+	}
+	s.saveTask(task)
+}
+
+// applyValidation sets the file status from the URL check and applies the
+// task size limit against the files accepted so far.
+func (s *TaskService) applyValidation(task *model.Task, file *model.File, info *validation.Info, err error) {
+	switch {
+	case err != nil:
+		file.Status = FileStatusFromError(err)
+		file.Error = err.Error()
+	case !FitsInTask(task.Files, info.ContentLength, s.cfg.MaxTaskSize):
+		s.logger.Warn("task size limit exceeded",
+			slog.String("url", file.URL),
+			slog.Int64("size", info.ContentLength),
+			slog.Int64("max_task_size", s.cfg.MaxTaskSize),
+		)
+		file.Status = model.FileStatusTooLarge
+		file.Error = fmt.Errorf("%w: task size limit %d exceeded", validation.ErrTooLarge, s.cfg.MaxTaskSize).Error()
+	default:
+		file.Status = model.FileStatusAccepted
+		file.ExpectedSize = info.ContentLength
+	}
+}
@@ -1,13 +1,131 @@
 package usecase
 
 import (
-	"path"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/folivorra/ziper/internal/adapter/downloader"
+	"github.com/folivorra/ziper/internal/model"
+	"github.com/folivorra/ziper/internal/netguard"
+	"github.com/folivorra/ziper/internal/transport/validation"
 )
 
-func IsAllowedFileType(url string) bool {
-	return path.Ext(url) == ".pdf" || path.Ext(url) == ".jpeg"
+// FitsInTask sums the sizes reported by HEAD for the accepted files; files
+// of unknown size are only limited while downloading.
+func FitsInTask(files []*model.File, size int64, maxTaskSize int64) bool {
+	if maxTaskSize <= 0 || size < 0 {
+		return true
+	}
+
+	total := size
+	for _, f := range files {
+		if f.Status == model.FileStatusAccepted && f.ExpectedSize > 0 {
+			total += f.ExpectedSize
+		}
+	}
+
+	return total <= maxTaskSize
+}
+
+func HasValidatingFiles(files []*model.File) bool {
+	for _, f := range files {
+		if f.Status == model.FileStatusValidating {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPendingFiles reports whether some file is still validating or has
+// passed validation, so the task has something to download.
+func HasPendingFiles(files []*model.File) bool {
+	for _, f := range files {
+		if f.Status == model.FileStatusValidating || f.Status == model.FileStatusAccepted {
+			return true
+		}
+	}
+	return false
+}
+
+func TaskResultStatus(files []*model.File, archived bool) model.TaskStatus {
+	if !archived {
+		return model.TaskStatusFailed
+	}
+
+	completed := 0
+	for _, f := range files {
+		if f.Status == model.FileStatusCompleted {
+			completed++
+		}
+	}
+
+	switch completed {
+	case 0:
+		return model.TaskStatusFailed
+	case len(files):
+		return model.TaskStatusCompleted
+	default:
+		return model.TaskStatusPartiallyCompleted
+	}
+}
+
+// IsValidSHA256 accepts an empty checksum, which means nothing to verify.
+func IsValidSHA256(sum string) bool {
+	if sum == "" {
+		return true
+	}
+	decoded, err := hex.DecodeString(sum)
+	return err == nil && len(decoded) == 32
+}
+
+// IsValidPriority accepts an empty priority, which means normal.
+func IsValidPriority(p model.TaskPriority) bool {
+	switch p {
+	case "", model.TaskPriorityHigh, model.TaskPriorityNormal, model.TaskPriorityLow:
+		return true
+	default:
+		return false
+	}
+}
+
+func ChecksumMatches(expected, actual string) bool {
+	return expected == "" || strings.EqualFold(expected, actual)
+}
+
+// IsExpired reports whether the task is past its expiry time. Queued and
+// running tasks never expire; their expiry is moved once they finish.
+func IsExpired(task *model.Task, now time.Time) bool {
+	if task.Status == model.TaskStatusExpired {
+		return true
+	}
+	if task.ExpiresAt.IsZero() || now.Before(task.ExpiresAt) {
+		return false
+	}
+	return task.Status != model.TaskStatusQueued && task.Status != model.TaskStatusInProgress
+}
+
+func HasArchiveURL(status model.TaskStatus) bool {
+	switch status {
+	case model.TaskStatusAccepted, model.TaskStatusCancelled, model.TaskStatusFailed, model.TaskStatusExpired:
+		return false
+	default:
+		return true
+	}
 }
 
-func CanAddFileInTask(activeFiles uint64, maxFiles uint64) bool {
-	return activeFiles < maxFiles
+func FileStatusFromError(err error) model.FileStatus {
+	switch {
+	case errors.Is(err, netguard.ErrBlocked):
+		return model.FileStatusBlockedURL
+	case errors.Is(err, validation.ErrNotReachable):
+		return model.FileStatusNotReachable
+	case errors.Is(err, validation.ErrNotSupportedType), errors.Is(err, downloader.ErrNotSupportedType):
+		return model.FileStatusNotSupportedType
+	case errors.Is(err, validation.ErrTooLarge), errors.Is(err, downloader.ErrTooLarge):
+		return model.FileStatusTooLarge
+	default:
+		return model.FileStatusFailed
+	}
 }
@@ -0,0 +1,67 @@
+package usecase
+
+import (
+	"testing"
+
+	"github.com/folivorra/ziper/internal/model"
+)
+
+func TestTaskResultStatus(t *testing.T) {
+	files := func(statuses ...model.FileStatus) []*model.File {
+		out := make([]*model.File, 0, len(statuses))
+		for _, status := range statuses {
+			out = append(out, &model.File{Status: status})
+		}
+		return out
+	}
+
+	tests := []struct {
+		name     string
+		files    []*model.File
+		archived bool
+		want     model.TaskStatus
+		wantURL  bool
+	}{
+		{"completed", files(model.FileStatusCompleted, model.FileStatusCompleted), true, model.TaskStatusCompleted, true},
+		{"partially completed", files(model.FileStatusCompleted, model.FileStatusNotReachable), true, model.TaskStatusPartiallyCompleted, true},
+		{"failed, no files downloaded", files(model.FileStatusFailed, model.FileStatusChecksumMismatch), true, model.TaskStatusFailed, false},
+		{"failed, no files at all", nil, true, model.TaskStatusFailed, false},
+		{"failed, archive error", files(model.FileStatusCompleted), false, model.TaskStatusFailed, false},
+		{"files rejected at validation", files(model.FileStatusCompleted, model.FileStatusTooLarge, model.FileStatusInvalidURL), true, model.TaskStatusPartiallyCompleted, true},
+		{"only rejected files", files(model.FileStatusNotSupportedType, model.FileStatusBlockedURL), true, model.TaskStatusFailed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TaskResultStatus(tt.files, tt.archived)
+			if got != tt.want {
+				t.Errorf("TaskResultStatus = %s, want %s", got, tt.want)
+			}
+			if HasArchiveURL(got) != tt.wantURL {
+				t.Errorf("HasArchiveURL(%s) = %v, want %v", got, !tt.wantURL, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestHasArchiveURL(t *testing.T) {
+	tests := []struct {
+		status model.TaskStatus
+		want   bool
+	}{
+		{model.TaskStatusAccepted, false},
+		{model.TaskStatusQueued, true},
+		{model.TaskStatusInProgress, true},
+		{model.TaskStatusCompleted, true},
+		{model.TaskStatusPartiallyCompleted, true},
+		{model.TaskStatusFailed, false},
+		{model.TaskStatusCancelled, false},
+		{model.TaskStatusExpired, false},
+	}
+
+	for _, tt := range tests {
+		if got := HasArchiveURL(tt.status); got != tt.want {
+			t.Errorf("HasArchiveURL(%s) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package usecase
+
+import (
+	"context"
+	urler "net/url"
+	"strings"
+	"sync"
+
+	"github.com/folivorra/ziper/internal/adapter/downloader"
+)
+
+// DownloadScheduler is shared by all tasks and keeps them from hammering a
+// single host: it caps concurrent downloads per host and, optionally, the
+// bytes per second per host and overall.
+type DownloadScheduler struct {
+	mu          sync.Mutex
+	hosts       map[string]*hostSlot
+	maxPerHost  int
+	hostRate    int64
+	globalLimit *TokenBucket
+}
+
+type hostSlot struct {
+	sem     *Semaphore
+	limiter *TokenBucket
+	users   int
+}
+
+// NewDownloadScheduler treats zero values as no limit.
+func NewDownloadScheduler(maxPerHost int, hostRate, globalRate int64) *DownloadScheduler {
+	ds := &DownloadScheduler{
+		hosts:      make(map[string]*hostSlot),
+		maxPerHost: maxPerHost,
+		hostRate:   hostRate,
+	}
+	if globalRate > 0 {
+		ds.globalLimit = NewTokenBucket(globalRate)
+	}
+	return ds
+}
+
+// Acquire waits for a free download slot on the url's host. The returned
+// limiter must be used for the download and release called when it ends.
+func (ds *DownloadScheduler) Acquire(ctx context.Context, url string) (downloader.RateLimiter, func(), error) {
+	host := hostOf(url)
+	slot := ds.join(host)
+
+	if slot.sem != nil {
+		if err := slot.sem.AcquireCtx(ctx); err != nil {
+			ds.leave(host)
+			return nil, nil, err
+		}
+	}
+
+	release := func() {
+		if slot.sem != nil {
+			slot.sem.Release()
+		}
+		ds.leave(host)
+	}
+
+	var limiter chainLimiter
+	if slot.limiter != nil {
+		limiter = append(limiter, slot.limiter)
+	}
+	if ds.globalLimit != nil {
+		limiter = append(limiter, ds.globalLimit)
+	}
+	if len(limiter) == 0 {
+		return nil, release, nil
+	}
+
+	return limiter, release, nil
+}
+
+func (ds *DownloadScheduler) join(host string) *hostSlot {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	slot, ok := ds.hosts[host]
+	if !ok {
+		slot = &hostSlot{}
+		if ds.maxPerHost > 0 {
+			slot.sem = NewSemaphore(ds.maxPerHost)
+		}
+		if ds.hostRate > 0 {
+			slot.limiter = NewTokenBucket(ds.hostRate)
+		}
+		ds.hosts[host] = slot
+	}
+	slot.users++
+
+	return slot
+}
+
+// leave drops the host entry once nobody uses it, so the table doesn't
+// grow with every host ever seen.
+func (ds *DownloadScheduler) leave(host string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	slot := ds.hosts[host]
+	slot.users--
+	if slot.users == 0 {
+		delete(ds.hosts, host)
+	}
+}
+
+func hostOf(url string) string {
+	parsed, err := urler.Parse(url)
+	if err != nil {
+		return url
+	}
+	return strings.ToLower(parsed.Hostname())
+}
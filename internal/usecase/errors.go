@@ -0,0 +1,18 @@
+package usecase
+
+import "errors"
+
+var (
+	ErrTaskNotFound         = errors.New("not found task")
+	ErrTaskNoFiles          = errors.New("task has no files")
+	ErrTaskAlreadySubmitted = errors.New("task already submitted")
+	ErrTaskNotCancellable   = errors.New("task can't be cancelled")
+	ErrArchiveNotReady      = errors.New("archive is not ready")
+	ErrUnsupportedFormat    = errors.New("unsupported archive format")
+	ErrInvalidPriority      = errors.New("invalid task priority")
+	ErrInvalidChecksum      = errors.New("invalid sha256 checksum")
+	ErrNoFilesGiven         = errors.New("no files given")
+	ErrNoFilesAccepted      = errors.New("none of the files can be added")
+	ErrTaskExpired          = errors.New("task expired")
+	ErrTaskBusy             = errors.New("task is busy, try again later")
+)
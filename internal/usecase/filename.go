@@ -0,0 +1,85 @@
+package usecase
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	fallbackFileName = "file"
+	maxFileNameLen   = 255
+)
+
+// SanitizeFileName turns an untrusted name into a single safe path
+// element: directories are dropped, control and reserved characters are
+// replaced and leading dots are removed, so "../x" can't escape the
+// archive and no hidden files appear.
+func SanitizeFileName(name string) string {
+	name = strings.ToValidUTF8(name, "_")
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return fallbackFileName
+	}
+
+	return truncateFileName(name, maxFileNameLen)
+}
+
+// archiveName sanitises the client supplied archive name; an empty name
+// stays empty and the default task-{id} name is used.
+func archiveName(name string) string {
+	if strings.TrimSpace(name) == "" {
+		return ""
+	}
+	return SanitizeFileName(name)
+}
+
+// UniqueFileName appends " (1)", " (2)", ... before the extension until the
+// name isn't taken; names are compared case-insensitively. The result is
+// marked as taken.
+func UniqueFileName(name string, taken map[string]bool) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	candidate := name
+	for i := 1; taken[strings.ToLower(candidate)]; i++ {
+		suffix := fmt.Sprintf(" (%d)%s", i, ext)
+		candidate = truncateFileName(stem, maxFileNameLen-len(suffix)) + suffix
+	}
+
+	taken[strings.ToLower(candidate)] = true
+
+	return candidate
+}
+
+// truncateFileName shortens the stem so the name fits in limit bytes,
+// keeping the extension and whole runes.
+func truncateFileName(name string, limit int) string {
+	if len(name) <= limit {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	if len(ext) >= limit {
+		ext = ""
+	}
+
+	stem := name[:limit-len(ext)]
+	for !utf8.ValidString(stem) {
+		stem = stem[:len(stem)-1]
+	}
+
+	return stem + ext
+}
@@ -0,0 +1,110 @@
+package usecase
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"  report.pdf  ", "report.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{`..\..\windows\system.ini`, "system.ini"},
+		{"/abs/path/a.jpg", "a.jpg"},
+		{"..", fallbackFileName},
+		{"dir/..", fallbackFileName},
+		{".hidden", "hidden"},
+		{"...", fallbackFileName},
+		{"name. . ", "name"},
+		{"", fallbackFileName},
+		{"   ", fallbackFileName},
+		{`a<b>c:d"e|f?g*h.txt`, "a_b_c_d_e_f_g_h.txt"},
+		{"tab\there\n.txt", "tab_here_.txt"},
+		{"bad\xffutf8.txt", "bad_utf8.txt"},
+		{"отчет 2024.pdf", "отчет 2024.pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeFileName(tt.name); got != tt.want {
+				t.Errorf("SanitizeFileName(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeFileNameTruncates(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantExt string
+	}{
+		{strings.Repeat("a", 300) + ".pdf", ".pdf"},
+		{strings.Repeat("я", 200) + ".jpeg", ".jpeg"},
+		{strings.Repeat("b", 300), ""},
+		{"x." + strings.Repeat("e", 300), ""},
+	}
+
+	for _, tt := range tests {
+		got := SanitizeFileName(tt.name)
+		if len(got) > maxFileNameLen {
+			t.Errorf("SanitizeFileName gave %d bytes, want at most %d", len(got), maxFileNameLen)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("SanitizeFileName gave invalid utf-8 %q", got)
+		}
+		if tt.wantExt != "" && !strings.HasSuffix(got, tt.wantExt) {
+			t.Errorf("SanitizeFileName lost the extension: %q", got)
+		}
+	}
+}
+
+func TestUniqueFileName(t *testing.T) {
+	tests := []struct {
+		name  string
+		taken []string
+		in    string
+		want  string
+	}{
+		{"free name", nil, "a.pdf", "a.pdf"},
+		{"taken name", []string{"a.pdf"}, "a.pdf", "a (1).pdf"},
+		{"several taken", []string{"a.pdf", "a (1).pdf", "a (2).pdf"}, "a.pdf", "a (3).pdf"},
+		{"case insensitive", []string{"A.PDF"}, "a.pdf", "a (1).pdf"},
+		{"no extension", []string{"readme"}, "readme", "readme (1)"},
+		{"double extension", []string{"data.tar.gz"}, "data.tar.gz", "data.tar (1).gz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taken := make(map[string]bool)
+			for _, n := range tt.taken {
+				taken[strings.ToLower(n)] = true
+			}
+
+			got := UniqueFileName(tt.in, taken)
+			if got != tt.want {
+				t.Errorf("UniqueFileName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if !taken[strings.ToLower(got)] {
+				t.Errorf("UniqueFileName(%q) didn't mark %q as taken", tt.in, got)
+			}
+		})
+	}
+}
+
+func TestUniqueFileNameLong(t *testing.T) {
+	name := strings.Repeat("a", maxFileNameLen-4) + ".pdf"
+	taken := map[string]bool{strings.ToLower(name): true}
+
+	got := UniqueFileName(name, taken)
+	if len(got) > maxFileNameLen {
+		t.Errorf("UniqueFileName gave %d bytes, want at most %d", len(got), maxFileNameLen)
+	}
+	if !strings.HasSuffix(got, " (1).pdf") {
+		t.Errorf("UniqueFileName(long) = %q, want a (1).pdf suffix", got)
+	}
+}
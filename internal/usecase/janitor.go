@@ -0,0 +1,169 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/folivorra/ziper/app"
+	"github.com/folivorra/ziper/internal/model"
+	"github.com/folivorra/ziper/internal/repository"
+)
+
+// Janitor periodically removes expired tasks together with their archives
+// and downloads. It is stopped by the app cleanup.
+type Janitor struct {
+	service  *TaskService
+	interval time.Duration
+	logger   *slog.Logger
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewJanitor(a *app.App, service *TaskService, interval time.Duration, logger *slog.Logger) *Janitor {
+	j := &Janitor{
+		service:  service,
+		interval: interval,
+		logger:   logger,
+		stop:     make(chan struct{}),
+	}
+
+	a.RegisterCleanup(func(ctx context.Context) {
+		close(j.stop)
+		j.wg.Wait()
+		j.logger.Info("janitor shutdown complete")
+	})
+
+	return j
+}
+
+func (j *Janitor) Start() {
+	if j.interval <= 0 {
+		return
+	}
+
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-j.stop:
+				return
+			case now := <-ticker.C:
+				if _, err := j.service.RemoveExpired(now); err != nil {
+					j.logger.Error("failed to remove expired tasks",
+						slog.String("error", err.Error()),
+					)
+				}
+			}
+		}
+	}()
+}
+
+// RemoveExpired deletes the tasks that expired by now and returns how many
+// were removed. A task is marked expired under its lock before it leaves
+// the repository, so a request that already holds the task refuses to
+// change it afterwards. Tasks whose lock is taken are left for the next
+// sweep instead of holding the sweep up.
+func (s *TaskService) RemoveExpired(now time.Time) (int, error) {
+	s.forgetRemoved(now)
+
+	expired, err := s.repo.List(repository.Filter{ExpiredBefore: now}, repository.Page{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list expired tasks: %w", err)
+	}
+
+	removed := 0
+	for _, snapshot := range expired {
+		// List returns snapshots, the expiry is changed on the task itself
+		task, err := s.repo.GetByID(snapshot.ID)
+		if err != nil {
+			continue
+		}
+
+		unlock, ok := s.lockManager.TryLock(task.ID)
+		if !ok {
+			continue
+		}
+
+		if !IsExpired(task, now) {
+			unlock()
+			continue
+		}
+
+		if task.Status == model.TaskStatusAccepted {
+			s.activeTasks.Add(^uint64(0))
+		}
+		task.Status = model.TaskStatusExpired
+
+		if err := s.repo.Delete(task.ID, now); err != nil {
+			s.logger.Error("error deleting expired task",
+				slog.Uint64("id", task.ID),
+				slog.String("error", err.Error()),
+			)
+		}
+		unlock()
+
+		s.removeTaskFiles(task)
+		removed++
+
+		s.logger.Info("expired task removed",
+			slog.Uint64("id", task.ID),
+		)
+	}
+
+	return removed, nil
+}
+
+func (s *TaskService) removeTaskFiles(task *model.Task) {
+	dirPath := filepath.Join(s.cfg.DownloadDir, fmt.Sprintf("task-%d", task.ID))
+	if err := os.RemoveAll(dirPath); err != nil {
+		s.logger.Warn("failed to remove task downloads",
+			slog.Uint64("id", task.ID),
+			slog.String("dir_path", dirPath),
+			slog.String("error", err.Error()),
+		)
+	}
+
+	if err := os.Remove(task.ArchivePath); err != nil && !os.IsNotExist(err) {
+		s.logger.Warn("failed to remove task archive",
+			slog.Uint64("id", task.ID),
+			slog.String("archive_path", task.ArchivePath),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// expiresAt is the expiry time for a task created or finished now; tasks
+// don't expire when TaskTTL is zero.
+func (s *TaskService) expiresAt() time.Time {
+	if s.cfg.TaskTTL <= 0 {
+		return time.Time{}
+	}
+	return time.Now().UTC().Add(s.cfg.TaskTTL)
+}
+
+// wasRemoved reports whether the task was removed by expiry recently; the
+// repository keeps the tombstone, so with a file repo it survives a restart.
+func (s *TaskService) wasRemoved(id uint64) bool {
+	_, ok := s.repo.RemovedAt(id)
+	return ok
+}
+
+// forgetRemoved drops the ids removed more than TaskTTL ago, so the
+// tombstones don't pile up.
+func (s *TaskService) forgetRemoved(now time.Time) {
+	if err := s.repo.ForgetRemoved(now.Add(-s.cfg.TaskTTL)); err != nil {
+		s.logger.Warn("failed to forget removed tasks",
+			slog.String("error", err.Error()),
+		)
+	}
+}
@@ -0,0 +1,77 @@
+package usecase
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/folivorra/ziper/internal/model"
+	"github.com/folivorra/ziper/internal/repository"
+)
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+)
+
+// ListQuery filters and pages the task list; zero fields match everything.
+// After is the id of the last task of the previous page.
+type ListQuery struct {
+	Statuses     []model.TaskStatus
+	CreatedAfter time.Time
+	Owner        string
+	After        uint64
+	Limit        int
+}
+
+type TaskList struct {
+	Tasks []*model.Task
+	Total int
+	// Next is the cursor of the next page, zero on the last one.
+	Next uint64
+}
+
+// ListTasks returns the last saved state of the matching tasks in id order.
+func (s *TaskService) ListTasks(query ListQuery) (*TaskList, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	limit = min(limit, MaxListLimit)
+
+	filter := repository.Filter{
+		Statuses:     query.Statuses,
+		CreatedAfter: query.CreatedAfter,
+		Owner:        query.Owner,
+	}
+
+	// one extra task tells whether there is a next page
+	tasks, err := s.repo.List(filter, repository.Page{After: query.After, Limit: limit + 1})
+	if err != nil {
+		s.logger.Error("error listing tasks", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+
+	total, err := s.repo.Count(filter)
+	if err != nil {
+		s.logger.Error("error counting tasks", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to count tasks: %w", err)
+	}
+
+	list := &TaskList{Total: total}
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+		list.Next = tasks[limit-1].ID
+	}
+
+	// the repository hands out snapshots, so no task lock is needed and a
+	// busy task shows its last saved state
+	for _, task := range tasks {
+		if !HasArchiveURL(task.Status) {
+			task.ArchiveURL = ""
+		}
+	}
+	list.Tasks = tasks
+
+	return list, nil
+}
@@ -1,27 +1,153 @@
 package usecase
 
-import "sync"
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
+// LockTaskManager hands out per-task locks. Entries are reference counted:
+// an entry lives only while somebody holds or waits for the lock, so the
+// table doesn't grow with every task ever created.
 type LockTaskManager struct {
 	mu    sync.Mutex
-	locks map[uint64]*sync.Mutex
+	locks map[uint64]*taskLock
+	stats lockStats
+}
+
+type taskLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+// LockStats describes lock contention since start. Locks is the number of
+// tasks whose lock is currently held or awaited.
+type LockStats struct {
+	Locks     int           `json:"locks"`
+	Waiting   int64         `json:"waiting"`
+	Acquired  uint64        `json:"acquired"`
+	Contended uint64        `json:"contended"`
+	Timeouts  uint64        `json:"timeouts"`
+	TotalWait time.Duration `json:"total_wait_ns"`
+	MaxWait   time.Duration `json:"max_wait_ns"`
+}
+
+type lockStats struct {
+	waiting   atomic.Int64
+	acquired  atomic.Uint64
+	contended atomic.Uint64
+	timeouts  atomic.Uint64
+	totalWait atomic.Int64
+	maxWait   atomic.Int64
 }
 
 func NewLockTaskManager() *LockTaskManager {
 	return &LockTaskManager{
-		locks: make(map[uint64]*sync.Mutex),
+		locks: make(map[uint64]*taskLock),
+	}
+}
+
+// Lock waits for the task lock until ctx is done. The returned unlock must
+// be called exactly once.
+func (m *LockTaskManager) Lock(ctx context.Context, id uint64) (func(), error) {
+	l := m.ref(id)
+
+	select {
+	case l.ch <- struct{}{}:
+		m.stats.acquired.Add(1)
+		return m.unlockFunc(id, l), nil
+	default:
+	}
+
+	m.stats.contended.Add(1)
+	m.stats.waiting.Add(1)
+	defer m.stats.waiting.Add(-1)
+
+	start := time.Now()
+	select {
+	case l.ch <- struct{}{}:
+		m.recordWait(time.Since(start))
+		m.stats.acquired.Add(1)
+		return m.unlockFunc(id, l), nil
+	case <-ctx.Done():
+		m.recordWait(time.Since(start))
+		m.stats.timeouts.Add(1)
+		m.unref(id, l)
+		return nil, ctx.Err()
+	}
+}
+
+// TryLock takes the task lock only if nobody holds it right now.
+func (m *LockTaskManager) TryLock(id uint64) (func(), bool) {
+	l := m.ref(id)
+
+	select {
+	case l.ch <- struct{}{}:
+		m.stats.acquired.Add(1)
+		return m.unlockFunc(id, l), true
+	default:
+		m.unref(id, l)
+		return nil, false
 	}
 }
 
-func (m *LockTaskManager) GetLock(id uint64) *sync.Mutex {
+func (m *LockTaskManager) Stats() LockStats {
+	m.mu.Lock()
+	locks := len(m.locks)
+	m.mu.Unlock()
+
+	return LockStats{
+		Locks:     locks,
+		Waiting:   m.stats.waiting.Load(),
+		Acquired:  m.stats.acquired.Load(),
+		Contended: m.stats.contended.Load(),
+		Timeouts:  m.stats.timeouts.Load(),
+		TotalWait: time.Duration(m.stats.totalWait.Load()),
+		MaxWait:   time.Duration(m.stats.maxWait.Load()),
+	}
+}
+
+func (m *LockTaskManager) unlockFunc(id uint64, l *taskLock) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-l.ch
+			m.unref(id, l)
+		})
+	}
+}
+
+func (m *LockTaskManager) ref(id uint64) *taskLock {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if l, ok := m.locks[id]; ok {
-		return l
+	l, ok := m.locks[id]
+	if !ok {
+		l = &taskLock{ch: make(chan struct{}, 1)}
+		m.locks[id] = l
 	}
+	l.refs++
 
-	lock := &sync.Mutex{}
-	m.locks[id] = lock
-	return lock
+	return l
+}
+
+func (m *LockTaskManager) unref(id uint64, l *taskLock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l.refs--
+	if l.refs == 0 {
+		delete(m.locks, id)
+	}
+}
+
+func (m *LockTaskManager) recordWait(d time.Duration) {
+	m.stats.totalWait.Add(int64(d))
+	for {
+		current := m.stats.maxWait.Load()
+		if int64(d) <= current || m.stats.maxWait.CompareAndSwap(current, int64(d)) {
+			return
+		}
+	}
 }
@@ -0,0 +1,148 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLockTaskManagerTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		held     bool
+		timeout  time.Duration
+		wantErr  error
+		contends bool
+	}{
+		{"free lock", false, 10 * time.Millisecond, nil, false},
+		{"held lock times out", true, 10 * time.Millisecond, context.DeadlineExceeded, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewLockTaskManager()
+			if tt.held {
+				unlock, err := m.Lock(context.Background(), 1)
+				if err != nil {
+					t.Fatalf("Lock: %v", err)
+				}
+				defer unlock()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			unlock, err := m.Lock(ctx, 1)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Lock = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				unlock()
+			}
+
+			stats := m.Stats()
+			if (stats.Contended == 1) != tt.contends {
+				t.Errorf("Contended = %d, want contended %v", stats.Contended, tt.contends)
+			}
+			if tt.wantErr != nil && (stats.Timeouts != 1 || stats.MaxWait < tt.timeout) {
+				t.Errorf("Timeouts = %d, MaxWait = %v, want 1 and at least %v", stats.Timeouts, stats.MaxWait, tt.timeout)
+			}
+			if stats.Waiting != 0 {
+				t.Errorf("Waiting = %d after the wait, want 0", stats.Waiting)
+			}
+		})
+	}
+}
+
+func TestLockTaskManagerRefCounting(t *testing.T) {
+	m := NewLockTaskManager()
+
+	unlock1, err := m.Lock(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Lock(1): %v", err)
+	}
+	unlock2, err := m.Lock(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("Lock(2): %v", err)
+	}
+	if got := m.Stats().Locks; got != 2 {
+		t.Fatalf("Locks = %d with two held, want 2", got)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		unlock, err := m.Lock(context.Background(), 1)
+		if err != nil {
+			t.Errorf("waiting Lock(1): %v", err)
+			close(acquired)
+			return
+		}
+		acquired <- unlock
+	}()
+
+	waitFor(t, func() bool { return m.Stats().Waiting == 1 })
+	if got := m.Stats().Locks; got != 2 {
+		t.Fatalf("Locks = %d with a waiter on a held lock, want 2", got)
+	}
+
+	unlock1()
+	// a second call must not release the lock the waiter now holds
+	unlock1()
+
+	unlock3, ok := <-acquired
+	if !ok {
+		t.FailNow()
+	}
+	if _, ok := m.TryLock(1); ok {
+		t.Fatal("TryLock(1) succeeded while the waiter holds the lock")
+	}
+
+	unlock3()
+	unlock2()
+
+	if got := m.Stats().Locks; got != 0 {
+		t.Fatalf("Locks = %d after every unlock, want 0", got)
+	}
+	if got := m.Stats().Acquired; got != 3 {
+		t.Errorf("Acquired = %d, want 3", got)
+	}
+}
+
+func TestLockTaskManagerTryLock(t *testing.T) {
+	m := NewLockTaskManager()
+
+	unlock, ok := m.TryLock(1)
+	if !ok {
+		t.Fatal("TryLock on a free lock failed")
+	}
+
+	if _, ok := m.TryLock(1); ok {
+		t.Fatal("TryLock on a held lock succeeded")
+	}
+	if got := m.Stats().Locks; got != 1 {
+		t.Errorf("Locks = %d after a failed TryLock, want 1", got)
+	}
+
+	unlock()
+	if got := m.Stats().Locks; got != 0 {
+		t.Errorf("Locks = %d after unlock, want 0", got)
+	}
+
+	unlock, ok = m.TryLock(1)
+	if !ok {
+		t.Fatal("TryLock after unlock failed")
+	}
+	unlock()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
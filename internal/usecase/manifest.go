@@ -0,0 +1,32 @@
+package usecase
+
+import (
+	"time"
+
+	"github.com/folivorra/ziper/internal/adapter/archiver"
+	"github.com/folivorra/ziper/internal/model"
+)
+
+// NewManifest describes the files of the task as they are now; the caller
+// must hold the task lock.
+func NewManifest(task *model.Task) *archiver.Manifest {
+	manifest := &archiver.Manifest{
+		TaskID:    task.ID,
+		CreatedAt: time.Now().UTC(),
+		Files:     make([]archiver.ManifestEntry, 0, len(task.Files)),
+	}
+
+	for _, f := range task.Files {
+		manifest.Files = append(manifest.Files, archiver.ManifestEntry{
+			URL:      f.URL,
+			Name:     f.Name,
+			Size:     f.Size,
+			SHA256:   f.SHA256,
+			Status:   string(f.Status),
+			Error:    f.Error,
+			Archived: f.Status == model.FileStatusCompleted,
+		})
+	}
+
+	return manifest
+}
@@ -0,0 +1,79 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket limits throughput to rate bytes per second with bursts of up
+// to one second worth of bytes.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(bytesPerSec int64) *TokenBucket {
+	return &TokenBucket{
+		rate:   float64(bytesPerSec),
+		burst:  float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// WaitN blocks until n bytes may pass. Requests bigger than the burst are
+// split, so any io.Copy buffer size works.
+func (b *TokenBucket) WaitN(ctx context.Context, n int) error {
+	remaining := float64(n)
+	for remaining > 0 {
+		chunk := min(remaining, b.burst)
+
+		delay := b.reserve(chunk)
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		remaining -= chunk
+	}
+	return nil
+}
+
+// reserve takes the tokens right away, going into debt if needed, and
+// returns how long to wait until the debt is paid off.
+func (b *TokenBucket) reserve(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// chainLimiter waits on every limiter in turn, e.g. the host and the
+// global one.
+type chainLimiter []*TokenBucket
+
+func (c chainLimiter) WaitN(ctx context.Context, n int) error {
+	for _, l := range c {
+		if err := l.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
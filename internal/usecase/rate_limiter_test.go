@@ -0,0 +1,87 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	const tolerance = 10 * time.Millisecond
+
+	tests := []struct {
+		name     string
+		empty    time.Duration
+		reserves []float64
+		want     []time.Duration
+	}{
+		{"within burst", 0, []float64{400, 600}, []time.Duration{0, 0}},
+		{"debt is paid off at rate", 0, []float64{1000, 500}, []time.Duration{0, 500 * time.Millisecond}},
+		{"debt adds up", 0, []float64{1000, 100, 100}, []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond}},
+		{"idle refills", 300 * time.Millisecond, []float64{300, 100}, []time.Duration{0, 100 * time.Millisecond}},
+		{"refill is capped at burst", time.Hour, []float64{1000, 200}, []time.Duration{0, 200 * time.Millisecond}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewTokenBucket(1000)
+			// empty is how long ago the bucket ran dry
+			if tt.empty > 0 {
+				b.tokens = 0
+				b.last = time.Now().Add(-tt.empty)
+			}
+
+			for i, n := range tt.reserves {
+				got := b.reserve(n)
+				if got < tt.want[i]-tolerance || got > tt.want[i]+tolerance {
+					t.Errorf("reserve #%d (%v) = %v, want %v", i, n, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTokenBucketWaitN(t *testing.T) {
+	b := NewTokenBucket(10000)
+
+	start := time.Now()
+	// a burst and a tenth of a second on top, split into chunks
+	if err := b.WaitN(context.Background(), 11000); err != nil {
+		t.Fatalf("WaitN: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("WaitN took %v, want about 100ms", elapsed)
+	}
+}
+
+func TestTokenBucketWaitNCancelled(t *testing.T) {
+	b := NewTokenBucket(1000)
+	b.reserve(1000)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := b.WaitN(ctx, 1000); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitN = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("WaitN returned after %v, want right after the deadline", elapsed)
+	}
+}
+
+func TestChainLimiter(t *testing.T) {
+	fast := NewTokenBucket(1 << 30)
+	slow := NewTokenBucket(10000)
+	slow.reserve(10000)
+
+	start := time.Now()
+	if err := (chainLimiter{fast, slow}).WaitN(context.Background(), 1000); err != nil {
+		t.Fatalf("WaitN: %v", err)
+	}
+	// the slowest limiter decides
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("WaitN took %v, want about 100ms", elapsed)
+	}
+}
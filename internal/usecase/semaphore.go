@@ -1,5 +1,7 @@
 package usecase
 
+import "context"
+
 type Semaphore struct {
 	C chan struct{}
 }
@@ -17,3 +19,12 @@ func (s *Semaphore) Acquire() {
 func (s *Semaphore) Release() {
 	<-s.C
 }
+
+func (s *Semaphore) AcquireCtx(ctx context.Context) error {
+	select {
+	case s.C <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,266 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/folivorra/ziper/internal/model"
+)
+
+var ErrQueueClosed = errors.New("task queue is closed")
+
+// TaskQueue hands submitted tasks to the workers. Push never blocks, so it
+// is safe to call with the task lock held.
+type TaskQueue interface {
+	Push(task *model.Task) error
+	// Pop waits for the next task until ctx is done or the queue is closed.
+	Pop(ctx context.Context) (*model.Task, error)
+	// Remove drops a task that no longer has to be processed.
+	Remove(id uint64) bool
+	// Position is the number of tasks that will be handed out before id.
+	Position(id uint64) (int, bool)
+	Len() int
+	Close()
+}
+
+// PriorityQueue serves higher priorities first. Within a priority the
+// clients (task owners) take turns, one task each, and the tasks of every
+// client keep their order, so a client that queues a lot of tasks doesn't
+// hold up the others. Tasks without an owner count as a single client.
+type PriorityQueue struct {
+	mu     sync.Mutex
+	levels [3]fairQueue
+	queued map[uint64]*clientTasks
+	wake   chan struct{}
+	done   chan struct{}
+	closed bool
+}
+
+var _ TaskQueue = (*PriorityQueue)(nil)
+
+func NewPriorityQueue() *PriorityQueue {
+	q := &PriorityQueue{
+		queued: make(map[uint64]*clientTasks),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	for i := range q.levels {
+		q.levels[i].clients = make(map[string]*clientTasks)
+	}
+	return q
+}
+
+func (q *PriorityQueue) Push(task *model.Task) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if _, ok := q.queued[task.ID]; ok {
+		return nil
+	}
+
+	q.queued[task.ID] = q.levels[priorityRank(task.Priority)].push(task)
+	q.signal()
+
+	return nil
+}
+
+func (q *PriorityQueue) Pop(ctx context.Context) (*model.Task, error) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return nil, ErrQueueClosed
+		}
+		for i := range q.levels {
+			if task := q.levels[i].pop(); task != nil {
+				delete(q.queued, task.ID)
+				// pass the turn on to another waiting worker
+				if len(q.queued) > 0 {
+					q.signal()
+				}
+				q.mu.Unlock()
+				return task, nil
+			}
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.wake:
+		case <-q.done:
+			return nil, ErrQueueClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (q *PriorityQueue) Remove(id uint64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c, ok := q.queued[id]
+	if !ok {
+		return false
+	}
+	delete(q.queued, id)
+	q.levels[c.level].remove(c, id)
+
+	return true
+}
+
+func (q *PriorityQueue) Position(id uint64) (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c, ok := q.queued[id]
+	if !ok {
+		return 0, false
+	}
+
+	ahead := 0
+	for i := 0; i < c.level; i++ {
+		ahead += q.levels[i].len()
+	}
+
+	return ahead + q.levels[c.level].position(c, id), true
+}
+
+func (q *PriorityQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.queued)
+}
+
+// Close wakes up the waiting workers; tasks left in the queue stay queued
+// in the repository and are picked up by Restore on the next start.
+func (q *PriorityQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.done)
+}
+
+func (q *PriorityQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// fairQueue is a single priority level: turn holds the clients with queued
+// tasks in the order they are served.
+type fairQueue struct {
+	turn    []*clientTasks
+	clients map[string]*clientTasks
+	size    int
+}
+
+type clientTasks struct {
+	owner string
+	level int
+	tasks []*model.Task
+}
+
+func (f *fairQueue) push(task *model.Task) *clientTasks {
+	c, ok := f.clients[task.Owner]
+	if !ok {
+		c = &clientTasks{owner: task.Owner, level: priorityRank(task.Priority)}
+		f.clients[task.Owner] = c
+		f.turn = append(f.turn, c)
+	}
+	c.tasks = append(c.tasks, task)
+	f.size++
+
+	return c
+}
+
+func (f *fairQueue) pop() *model.Task {
+	if len(f.turn) == 0 {
+		return nil
+	}
+
+	c := f.turn[0]
+	task := c.tasks[0]
+	c.tasks = c.tasks[1:]
+	f.size--
+
+	f.turn = f.turn[1:]
+	if len(c.tasks) > 0 {
+		f.turn = append(f.turn, c)
+	} else {
+		delete(f.clients, c.owner)
+	}
+
+	return task
+}
+
+func (f *fairQueue) remove(c *clientTasks, id uint64) {
+	for i, task := range c.tasks {
+		if task.ID == id {
+			c.tasks = append(c.tasks[:i], c.tasks[i+1:]...)
+			f.size--
+			break
+		}
+	}
+	if len(c.tasks) > 0 {
+		return
+	}
+
+	delete(f.clients, c.owner)
+	for i, other := range f.turn {
+		if other == c {
+			f.turn = append(f.turn[:i], f.turn[i+1:]...)
+			break
+		}
+	}
+}
+
+// position follows the order pop hands the tasks out in: the k-th task of
+// a client comes after the first k tasks of every client and after the
+// k-th tasks of the clients ahead of it in turn.
+func (f *fairQueue) position(c *clientTasks, id uint64) int {
+	k := 0
+	for k < len(c.tasks) && c.tasks[k].ID != id {
+		k++
+	}
+
+	ahead := 0
+	before := true
+	for _, other := range f.turn {
+		if other == c {
+			before = false
+		}
+		ahead += min(len(other.tasks), k)
+		if before && len(other.tasks) > k {
+			ahead++
+		}
+	}
+
+	return ahead
+}
+
+func (f *fairQueue) len() int {
+	return f.size
+}
+
+// priorityRank orders the levels from the highest priority; tasks stored
+// before priorities existed are normal.
+func priorityRank(p model.TaskPriority) int {
+	switch p {
+	case model.TaskPriorityHigh:
+		return 0
+	case model.TaskPriorityLow:
+		return 2
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,211 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/folivorra/ziper/internal/model"
+)
+
+type queuedTask struct {
+	id       uint64
+	owner    string
+	priority model.TaskPriority
+}
+
+func pushAll(t *testing.T, q *PriorityQueue, tasks []queuedTask) {
+	t.Helper()
+	for _, qt := range tasks {
+		if err := q.Push(&model.Task{ID: qt.id, Owner: qt.owner, Priority: qt.priority}); err != nil {
+			t.Fatalf("Push(%d): %v", qt.id, err)
+		}
+	}
+}
+
+func popAll(t *testing.T, q *PriorityQueue) []uint64 {
+	t.Helper()
+	var ids []uint64
+	for q.Len() > 0 {
+		task, err := q.Pop(context.Background())
+		if err != nil {
+			t.Fatalf("Pop: %v", err)
+		}
+		ids = append(ids, task.ID)
+	}
+	return ids
+}
+
+func TestPriorityQueueOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		tasks  []queuedTask
+		remove []uint64
+		want   []uint64
+	}{
+		{
+			name:  "single client keeps order",
+			tasks: []queuedTask{{1, "a", ""}, {2, "a", ""}, {3, "a", ""}},
+			want:  []uint64{1, 2, 3},
+		},
+		{
+			name: "clients take turns",
+			tasks: []queuedTask{
+				{1, "a", ""}, {2, "a", ""}, {3, "a", ""},
+				{4, "b", ""}, {5, "b", ""},
+				{6, "c", ""},
+			},
+			want: []uint64{1, 4, 6, 2, 5, 3},
+		},
+		{
+			name: "higher priority first",
+			tasks: []queuedTask{
+				{1, "a", model.TaskPriorityLow},
+				{2, "a", model.TaskPriorityNormal},
+				{3, "b", model.TaskPriorityHigh},
+				{4, "a", ""},
+			},
+			want: []uint64{3, 2, 4, 1},
+		},
+		{
+			name:  "tasks without owner share a turn",
+			tasks: []queuedTask{{1, "", ""}, {2, "", ""}, {3, "a", ""}},
+			want:  []uint64{1, 3, 2},
+		},
+		{
+			name: "removed tasks are skipped",
+			tasks: []queuedTask{
+				{1, "a", ""}, {2, "a", ""},
+				{3, "b", ""},
+				{4, "c", ""}, {5, "c", ""},
+			},
+			remove: []uint64{3, 4},
+			want:   []uint64{1, 5, 2},
+		},
+		{
+			name:  "duplicate push is ignored",
+			tasks: []queuedTask{{1, "a", ""}, {1, "a", ""}, {2, "b", ""}},
+			want:  []uint64{1, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewPriorityQueue()
+			pushAll(t, q, tt.tasks)
+			for _, id := range tt.remove {
+				if !q.Remove(id) {
+					t.Fatalf("Remove(%d) = false", id)
+				}
+			}
+
+			got := popAll(t, q)
+			if len(got) != len(tt.want) {
+				t.Fatalf("popped %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("popped %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPriorityQueuePosition(t *testing.T) {
+	tests := []struct {
+		name   string
+		tasks  []queuedTask
+		popped int
+	}{
+		{
+			name:  "single client",
+			tasks: []queuedTask{{1, "a", ""}, {2, "a", ""}, {3, "a", ""}},
+		},
+		{
+			name: "uneven clients",
+			tasks: []queuedTask{
+				{1, "a", ""}, {2, "a", ""}, {3, "a", ""}, {4, "a", ""},
+				{5, "b", ""},
+				{6, "c", ""}, {7, "c", ""},
+			},
+		},
+		{
+			name: "after some pops",
+			tasks: []queuedTask{
+				{1, "a", ""}, {2, "a", ""}, {3, "a", ""},
+				{4, "b", ""}, {5, "b", ""},
+				{6, "c", ""},
+			},
+			popped: 2,
+		},
+		{
+			name: "mixed priorities",
+			tasks: []queuedTask{
+				{1, "a", model.TaskPriorityLow}, {2, "a", model.TaskPriorityLow},
+				{3, "b", ""}, {4, "b", ""},
+				{5, "c", model.TaskPriorityHigh},
+				{6, "a", ""},
+				{7, "c", model.TaskPriorityLow},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewPriorityQueue()
+			pushAll(t, q, tt.tasks)
+			for i := 0; i < tt.popped; i++ {
+				if _, err := q.Pop(context.Background()); err != nil {
+					t.Fatalf("Pop: %v", err)
+				}
+			}
+
+			positions := make(map[uint64]int)
+			for _, qt := range tt.tasks {
+				if pos, ok := q.Position(qt.id); ok {
+					positions[qt.id] = pos
+				}
+			}
+			if len(positions) != len(tt.tasks)-tt.popped {
+				t.Fatalf("%d tasks have a position, want %d", len(positions), len(tt.tasks)-tt.popped)
+			}
+
+			// the positions must match the order Pop really hands them out in
+			for i, id := range popAll(t, q) {
+				if positions[id] != i {
+					t.Errorf("task %d: Position = %d, popped as %d", id, positions[id], i)
+				}
+			}
+		})
+	}
+}
+
+func TestPriorityQueuePopWaits(t *testing.T) {
+	q := NewPriorityQueue()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Push(&model.Task{ID: 1})
+	}()
+
+	task, err := q.Pop(context.Background())
+	if err != nil || task.ID != 1 {
+		t.Fatalf("Pop = %v, %v, want task 1", task, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.Pop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Pop on empty queue = %v, want deadline exceeded", err)
+	}
+
+	q.Close()
+	if _, err := q.Pop(context.Background()); !errors.Is(err, ErrQueueClosed) {
+		t.Fatalf("Pop after Close = %v, want ErrQueueClosed", err)
+	}
+	if err := q.Push(&model.Task{ID: 2}); !errors.Is(err, ErrQueueClosed) {
+		t.Fatalf("Push after Close = %v, want ErrQueueClosed", err)
+	}
+}
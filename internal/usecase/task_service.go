@@ -1,12 +1,18 @@
 package usecase
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
-	net "net/url"
-	"path"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/folivorra/ziper/internal/adapter/archiver"
 	"github.com/folivorra/ziper/internal/adapter/downloader"
@@ -24,9 +30,14 @@ type TaskService struct {
 	lockManager *LockTaskManager
 	validr      validation.FileValidator
 	dowloadr    downloader.Downloader
-	archiver    archiver.Archiver
+	archivers   map[model.ArchiveFormat]archiver.Archiver
+	scheduler   *DownloadScheduler
 	logger      *slog.Logger
-	taskQueue   chan *model.Task
+	taskQueue   TaskQueue
+
+	cancelMu       sync.Mutex
+	cancels        map[uint64]context.CancelCauseFunc
+	cancelRequests map[uint64]struct{}
 }
 
 func NewTaskService(
@@ -36,8 +47,9 @@ func NewTaskService(
 	locker *LockTaskManager,
 	validr validation.FileValidator,
 	dowloadr downloader.Downloader,
-	archiver archiver.Archiver,
-	taskQueue chan *model.Task,
+	archivers map[model.ArchiveFormat]archiver.Archiver,
+	scheduler *DownloadScheduler,
+	taskQueue TaskQueue,
 ) *TaskService {
 	return &TaskService{
 		repo:        repo,
@@ -45,14 +57,82 @@ func NewTaskService(
 		lockManager: locker,
 		validr:      validr,
 		dowloadr:    dowloadr,
-		archiver:    archiver,
+		archivers:   archivers,
+		scheduler:   scheduler,
 		logger:      logger,
 		taskQueue:   taskQueue,
+
+		cancels:        make(map[uint64]context.CancelCauseFunc),
+		cancelRequests: make(map[uint64]struct{}),
 	}
 }
 
-func (s *TaskService) CreateTask() (uint64, error) {
-	s.logger.Info("creating new task")
+// TaskOptions describes a new task. Name is the file name offered when the
+// archive is downloaded. Files are added right away and, with Submit, the
+// task goes to the queue in the same call.
+type TaskOptions struct {
+	Format   model.ArchiveFormat
+	Name     string
+	Owner    string
+	Priority model.TaskPriority
+	Files    []FileRequest
+	Submit   bool
+}
+
+// CreatedTask is the outcome of CreateTask. Error says why a task asked to
+// be submitted stayed accepted.
+type CreatedTask struct {
+	ID            uint64
+	Status        model.TaskStatus
+	SubmitPending bool
+	Files         []FileResult
+	Error         string
+}
+
+// CreateTask creates the task and adds opts.Files to it. When files are
+// given and all of them fail the checks that need no request, no task is
+// created: the per-file results come back with ErrNoFilesAccepted.
+func (s *TaskService) CreateTask(opts TaskOptions) (*CreatedTask, error) {
+	format := opts.Format
+	s.logger.Info("creating new task",
+		slog.String("format", string(format)),
+		slog.Int("files", len(opts.Files)),
+	)
+
+	if format == "" {
+		format = model.ArchiveFormatZip
+	}
+
+	arch, ok := s.archivers[format]
+	if !ok {
+		s.logger.Warn("unsupported archive format",
+			slog.String("format", string(format)),
+		)
+		return nil, fmt.Errorf("%w %s", ErrUnsupportedFormat, format)
+	}
+
+	priority := opts.Priority
+	if !IsValidPriority(priority) {
+		return nil, fmt.Errorf("%w %s", ErrInvalidPriority, priority)
+	}
+	if priority == "" {
+		priority = model.TaskPriorityNormal
+	}
+
+	if len(opts.Files) > 0 {
+		results, _ := s.checkFiles(opts.Files, 0)
+		accepted := false
+		for _, res := range results {
+			if res.Status == model.FileStatusValidating {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			s.logger.Warn("task not created, all files rejected")
+			return &CreatedTask{Files: results}, ErrNoFilesAccepted
+		}
+	}
 
 	for {
 		current := s.activeTasks.Load()
@@ -61,7 +141,7 @@ func (s *TaskService) CreateTask() (uint64, error) {
 				slog.Uint64("max tasks", s.cfg.MaxTasks),
 				slog.Uint64("active tasks", current),
 			)
-			return 0, fmt.Errorf("active tasks exceeds max tasks %d", s.cfg.MaxTasks)
+			return nil, fmt.Errorf("active tasks exceeds max tasks %d", s.cfg.MaxTasks)
 		}
 		if s.activeTasks.CompareAndSwap(current, current+1) {
 			break
@@ -73,20 +153,74 @@ func (s *TaskService) CreateTask() (uint64, error) {
 		ID:          id,
 		Status:      model.TaskStatusAccepted,
 		Files:       make([]*model.File, 0, s.cfg.MaxFilesInTask),
-		ArchiveURL:  fmt.Sprintf("http://localhost:%s/%s/task-%d.zip", s.cfg.Port, s.cfg.ArchDir, id),
-		ArchivePath: fmt.Sprintf("%s/task-%d.zip", s.cfg.ArchDir, id),
+		Format:      format,
+		ArchiveName: archiveName(opts.Name),
+		Owner:       opts.Owner,
+		Priority:    priority,
+		CreatedAt:   time.Now().UTC(),
+		ExpiresAt:   s.expiresAt(),
+		ArchiveURL:  fmt.Sprintf("http://localhost:%s/%s/task-%d%s", s.cfg.Port, s.cfg.ArchDir, id, arch.Extension()),
+		ArchivePath: fmt.Sprintf("%s/task-%d%s", s.cfg.ArchDir, id, arch.Extension()),
+	}
+
+	// the id is new, so the lock is free; it is taken before the task is
+	// saved, so nobody sees the task without its files
+	unlock, _ := s.lockManager.Lock(context.Background(), id)
+	defer unlock()
+
+	if err := s.repo.Save(task); err != nil {
+		s.activeTasks.Add(^uint64(0))
+		s.logger.Error("error saving task",
+			slog.Uint64("id", id),
+			slog.String("error", err.Error()),
+		)
+		return nil, fmt.Errorf("failed to save task %d", id)
 	}
-	s.repo.Save(task)
 
 	s.logger.Info("task created")
 
-	return id, nil
+	created := &CreatedTask{ID: id, Status: model.TaskStatusAccepted}
+	if len(opts.Files) == 0 {
+		return created, nil
+	}
+
+	// the checks above passed, so at least one file is added
+	results, _ := s.addFiles(task, opts.Files)
+	created.Files = results
+
+	submitted := false
+	if opts.Submit || s.cfg.AutoSubmit && len(task.Files) == int(s.cfg.MaxFilesInTask) {
+		if err := s.submit(task); err == nil {
+			submitted = true
+		} else if opts.Submit {
+			created.Error = err.Error()
+		}
+	}
+	if !submitted {
+		s.saveTask(task)
+	}
+	created.Status = task.Status
+	created.SubmitPending = task.SubmitPending
+
+	return created, nil
 }
 
-func (s *TaskService) AddFileByID(id uint64, url string) (model.FileStatus, error) {
-	s.logger.Info("adding file to task",
+// AddFileByID adds url to the task. A non-empty sha256 is checked against
+// the downloaded file and a non-empty name is used for it in the archive.
+func (s *TaskService) AddFileByID(id uint64, url, sha256, name string) (model.FileStatus, error) {
+	results, err := s.AddFiles(id, []FileRequest{{URL: url, SHA256: sha256, Name: name}})
+	if err != nil {
+		return model.FileStatusFailed, err
+	}
+
+	return results[0].Status, results[0].Err
+}
+
+// GetTask returns a snapshot of the task with its files. The archive URL is
+// left empty until the task is submitted.
+func (s *TaskService) GetTask(id uint64) (*model.Task, error) {
+	s.logger.Info("getting task",
 		slog.Uint64("id", id),
-		slog.String("url", url),
 	)
 
 	task, err := s.repo.GetByID(id)
@@ -95,68 +229,104 @@ func (s *TaskService) AddFileByID(id uint64, url string) (model.FileStatus, erro
 			slog.Uint64("id", id),
 			slog.String("error", err.Error()),
 		)
-		return model.FileStatusFailed, fmt.Errorf("not found task by id %d", id)
+		if s.wasRemoved(id) {
+			return nil, fmt.Errorf("%w by id %d", ErrTaskExpired, id)
+		}
+		return nil, fmt.Errorf("%w by id %d", ErrTaskNotFound, id)
 	}
 
-	lock := s.lockManager.GetLock(id)
-	lock.Lock()
-	defer lock.Unlock()
+	unlock, err := s.lockTask(id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
-	if !CanAddFileInTask(uint64(len(task.Files)), s.cfg.MaxFilesInTask) {
-		s.logger.Error("task exceeds max files",
-			slog.Uint64("maxFilesInTask", s.cfg.MaxFilesInTask),
-			slog.Uint64("currentFiles", uint64(len(task.Files))),
-		)
-		return model.FileStatusFailed, fmt.Errorf("task exceeds max files %d", s.cfg.MaxFilesInTask)
+	if IsExpired(task, time.Now()) {
+		return nil, fmt.Errorf("%w by id %d", ErrTaskExpired, id)
 	}
 
-	status := model.FileStatusAccepted
-	var returningErr error
+	snapshot := task.Clone()
+	if !HasArchiveURL(task.Status) {
+		snapshot.ArchiveURL = ""
+	}
+
+	return snapshot, nil
+}
+
+func (s *TaskService) ArchiveContentType(format model.ArchiveFormat) string {
+	if format == "" {
+		format = model.ArchiveFormatZip
+	}
+	if arch, ok := s.archivers[format]; ok {
+		return arch.ContentType()
+	}
+	return "application/octet-stream"
+}
 
-	if _, err := net.ParseRequestURI(url); err != nil {
-		s.logger.Warn("invalid url",
-			slog.String("url", url),
+// ArchiveFileName is the name the archive is offered under for download.
+func (s *TaskService) ArchiveFileName(task *model.Task) string {
+	if task.ArchiveName == "" {
+		return filepath.Base(task.ArchivePath)
+	}
+
+	ext := s.archiverFor(task).Extension()
+	if strings.HasSuffix(strings.ToLower(task.ArchiveName), ext) {
+		return task.ArchiveName
+	}
+	return task.ArchiveName + ext
+}
+
+func (s *TaskService) IsStreamingArchives() bool {
+	return s.cfg.IsStreamingArchives()
+}
+
+// StreamArchive zips the downloaded files of a finished task straight into w.
+// The task lock is only held to check the status, not while streaming.
+func (s *TaskService) StreamArchive(ctx context.Context, id uint64, w io.Writer) error {
+	task, err := s.repo.GetByID(id)
+	if err != nil {
+		s.logger.Error("error getting task by id",
+			slog.Uint64("id", id),
 			slog.String("error", err.Error()),
 		)
-		status = model.FileStatusInvalidURL
-		returningErr = fmt.Errorf("invalid url %s", url)
-	} else if !IsAllowedFileType(url) {
-		s.logger.Warn("not supported file type",
-			slog.String("file type", path.Ext(url)),
-		)
-		status = model.FileStatusNotSupportedType
-		returningErr = fmt.Errorf("not supported file type %s", path.Ext(url))
-	} else if !s.validr.IsReachable(url) {
-		s.logger.Warn("file not reachable",
-			slog.String("url", url),
-		)
-		status = model.FileStatusNotReachable
-		returningErr = fmt.Errorf("file not reachable %s", url)
+		return fmt.Errorf("%w by id %d", ErrTaskNotFound, id)
 	}
 
-	file := &model.File{
-		Status: status,
-		URL:    url,
+	unlock, err := s.lockTask(id)
+	if err != nil {
+		return err
 	}
+	status := task.Status
+	manifest := NewManifest(task)
+	unlock()
 
-	task.Files = append(task.Files, file)
-
-	if len(task.Files) == int(s.cfg.MaxFilesInTask) {
-		s.taskQueue <- task
-		s.logger.Info("task goes to queue")
+	if status != model.TaskStatusCompleted && status != model.TaskStatusPartiallyCompleted {
+		return fmt.Errorf("%w with status %s", ErrArchiveNotReady, status)
 	}
 
-	s.logger.Info("added file to task",
-		slog.Uint64("task_id", task.ID),
-		slog.String("file_status", string(file.Status)),
-		slog.String("file_url", file.URL),
+	dirPath := filepath.Join(s.cfg.DownloadDir, fmt.Sprintf("task-%d", id))
+
+	s.logger.Info("streaming archive",
+		slog.Uint64("id", id),
+		slog.String("dir_path", dirPath),
 	)
 
-	return status, returningErr
+	if err := s.archiverFor(task).WriteArchive(ctx, w, dirPath, manifest); err != nil {
+		s.logger.Error("error streaming archive",
+			slog.Uint64("id", id),
+			slog.String("error", err.Error()),
+		)
+		return fmt.Errorf("failed to stream archive: %w", err)
+	}
+
+	return nil
 }
 
-func (s *TaskService) GetTaskStatusAndArchiveURL(id uint64) (model.TaskStatus, string, error) {
-	s.logger.Info("getting task status",
+// SubmitTask sends the task to the queue. While some of its files are
+// still validating the task stays accepted and is queued after the last
+// check; the returned status tells which happened.
+func (s *TaskService) SubmitTask(id uint64) (model.TaskStatus, error) {
+	s.logger.Info("submitting task",
 		slog.Uint64("id", id),
 	)
 
@@ -166,41 +336,63 @@ func (s *TaskService) GetTaskStatusAndArchiveURL(id uint64) (model.TaskStatus, s
 			slog.Uint64("id", id),
 			slog.String("error", err.Error()),
 		)
-		return "", "", fmt.Errorf("not found task by id %d", id)
+		return "", fmt.Errorf("%w by id %d", ErrTaskNotFound, id)
 	}
 
-	lock := s.lockManager.GetLock(task.ID)
-	lock.Lock()
-	defer lock.Unlock()
+	unlock, err := s.lockTask(id)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
 
-	status := task.Status
-	archURL := ""
-	if len(task.Files) == int(s.cfg.MaxFilesInTask) || task.Status == model.TaskStatusCompleted {
-		archURL = task.ArchiveURL
-		s.logger.Info("got archive url",
-			slog.Uint64("task_id", task.ID),
+	if task.Status != model.TaskStatusAccepted || task.SubmitPending {
+		s.logger.Warn("task already submitted",
+			slog.Uint64("id", id),
+			slog.String("status", string(task.Status)),
 		)
+		return "", fmt.Errorf("%w with status %s", ErrTaskAlreadySubmitted, task.Status)
 	}
 
-	s.logger.Info("got task status",
-		slog.Uint64("task_id", task.ID),
-	)
+	if len(task.Files) == 0 {
+		s.logger.Warn("task has no files",
+			slog.Uint64("id", id),
+		)
+		return "", fmt.Errorf("%w to submit", ErrTaskNoFiles)
+	}
 
-	return status, archURL, nil
+	if err := s.submit(task); err != nil {
+		s.logger.Warn("task has no files to download",
+			slog.Uint64("id", id),
+		)
+		return "", err
+	}
+
+	return task.Status, nil
 }
 
-func (s *TaskService) ProcessTask(task *model.Task) error {
-	defer s.activeTasks.Add(^uint64(0))
+// ProcessTask downloads the files and builds the archive. The task lock is
+// only taken to change the task, never across network I/O or archiving,
+// so the task can be polled while it runs; a cancel reaches it through the
+// registered cancel func.
+func (s *TaskService) ProcessTask(ctx context.Context, task *model.Task) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	s.registerCancel(task.ID, cancel)
+	defer s.unregisterCancel(task.ID)
 
 	s.logger.Info("processing task",
 		slog.Uint64("id", task.ID),
 	)
 
-	lock := s.lockManager.GetLock(task.ID)
-	lock.Lock()
-	defer lock.Unlock()
+	// a cancelled task stops waiting for the lock as well
+	unlock, err := s.lockManager.Lock(ctx, task.ID)
+	if err != nil {
+		return fmt.Errorf("task %d cancelled: %w", task.ID, err)
+	}
 
-	if task.Status != model.TaskStatusAccepted {
+	if task.Status != model.TaskStatusQueued {
+		unlock()
 		s.logger.Warn("task already processed",
 			slog.Uint64("id", task.ID),
 			slog.String("status", string(task.Status)),
@@ -208,64 +400,152 @@ func (s *TaskService) ProcessTask(task *model.Task) error {
 		return fmt.Errorf("task already processed with status %s", task.Status)
 	}
 
+	defer s.activeTasks.Add(^uint64(0))
+
 	task.Status = model.TaskStatusInProgress
+	s.saveTask(task)
+
+	type download struct {
+		index int
+		file  *model.File
+		url   string
+	}
+	downloads := make([]download, 0, len(task.Files))
+	for i, file := range task.Files {
+		if file.Status == model.FileStatusAccepted {
+			downloads = append(downloads, download{index: i, file: file, url: file.URL})
+		}
+	}
+	results := make([]*downloader.Result, len(task.Files))
+	unlock()
 
-	dirPath := fmt.Sprintf("downloads/task-%d", task.ID)
+	dirPath := filepath.Join(s.cfg.DownloadDir, fmt.Sprintf("task-%d", task.ID))
+
+	limits := downloader.Limits{MaxFileSize: s.cfg.MaxFileSize}
+	if s.cfg.MaxTaskSize > 0 {
+		limits.TaskBudget = downloader.NewBudget(s.cfg.MaxTaskSize)
+	}
 
 	sem := NewSemaphore(int(s.cfg.MaxFilesInTask))
 	var wg sync.WaitGroup
 
-	for _, file := range task.Files {
+	for _, d := range downloads {
+		if ctx.Err() != nil {
+			break
+		}
 		sem.Acquire()
 		wg.Add(1)
-		go func(file *model.File) {
+		go func(d download) {
 			defer wg.Done()
 			defer sem.Release()
 			defer func() {
 				if r := recover(); r != nil {
 					s.logger.Error("panic during file processing",
 						slog.Uint64("task_id", task.ID),
-						slog.String("file_url", file.URL),
+						slog.String("file_url", d.url),
 						slog.Any("error", r),
 					)
-					file.Status = model.FileStatusFailed
+					s.withTaskLock(task.ID, func() {
+						d.file.Status = model.FileStatusFailed
+						d.file.Error = fmt.Sprint(r)
+						d.file.FinishedAt = time.Now()
+					})
 				}
 			}()
 
 			s.logger.Info("downloading file",
 				slog.Uint64("task_id", task.ID),
-				slog.String("file_url", file.URL),
+				slog.String("file_url", d.url),
 			)
 
-			err := s.dowloadr.DownloadFile(file.URL, task.ID)
+			limiter, release, err := s.scheduler.Acquire(ctx, d.url)
+			if err != nil {
+				s.withTaskLock(task.ID, func() {
+					d.file.Status = model.FileStatusFailed
+					d.file.Error = err.Error()
+				})
+				return
+			}
+			defer release()
+
+			fileLimits := limits
+			fileLimits.RateLimiter = limiter
+
+			s.withTaskLock(task.ID, func() {
+				d.file.StartedAt = time.Now()
+			})
+			res, err := s.dowloadr.DownloadFile(ctx, d.url, task.ID, fileLimits)
+			finishedAt := time.Now()
+
 			if err != nil {
 				s.logger.Error("error downloading file",
 					slog.Uint64("task_id", task.ID),
-					slog.String("file_url", file.URL),
+					slog.String("file_url", d.url),
 					slog.String("error", err.Error()),
 				)
-				file.Status = model.FileStatusFailed
-			} else {
-				file.Status = model.FileStatusCompleted
-				s.logger.Info("file downloading successfully",
-					slog.Uint64("task_id", task.ID),
-					slog.String("file_url", file.URL),
-				)
 			}
-		}(file)
+
+			s.withTaskLock(task.ID, func() {
+				s.recordDownload(task, d.file, res, err, finishedAt)
+				if d.file.Status == model.FileStatusCompleted {
+					results[d.index] = res
+				}
+			})
+		}(d)
 	}
 
 	wg.Wait()
 
-	if err := s.archiver.ArchiveDirectory(dirPath); err != nil {
-		s.logger.Error("error adding file to archive",
-			slog.Uint64("task_id", task.ID),
-			slog.String("dir_path", dirPath),
-			slog.String("error", err.Error()),
+	unlock, _ = s.lockManager.Lock(context.Background(), task.ID)
+	archive := false
+	if ctx.Err() == nil {
+		s.nameFiles(task, dirPath, results)
+		if TaskResultStatus(task.Files, true) != model.TaskStatusFailed {
+			archive = true
+		} else {
+			task.Error = "no files were downloaded"
+		}
+	}
+	manifest := NewManifest(task)
+	arch := s.archiverFor(task)
+	unlock()
+
+	archived := false
+	if archive {
+		if s.cfg.IsStreamingArchives() {
+			archived = true
+		} else if err := arch.ArchiveDirectory(ctx, dirPath, manifest); err != nil {
+			s.logger.Error("error adding file to archive",
+				slog.Uint64("task_id", task.ID),
+				slog.String("dir_path", dirPath),
+				slog.String("error", err.Error()),
+			)
+			s.withTaskLock(task.ID, func() {
+				task.Error = fmt.Sprintf("failed to create archive: %s", err)
+			})
+		} else {
+			archived = true
+		}
+	}
+
+	unlock, _ = s.lockManager.Lock(context.Background(), task.ID)
+	defer unlock()
+
+	if errors.Is(context.Cause(ctx), errCancelRequested) {
+		s.abortTask(task)
+		return fmt.Errorf("task %d cancelled: %w", task.ID, ctx.Err())
+	}
+	if ctx.Err() != nil {
+		// shutting down: the task stays in_progress and Restore runs it again
+		s.logger.Info("task processing interrupted",
+			slog.Uint64("id", task.ID),
 		)
+		return fmt.Errorf("task %d interrupted: %w", task.ID, ctx.Err())
 	}
 
-	task.Status = model.TaskStatusCompleted
+	task.Status = TaskResultStatus(task.Files, archived)
+	task.ExpiresAt = s.expiresAt()
+	s.saveTask(task)
 
 	s.logger.Info("task processing completed",
 		slog.Uint64("id", task.ID),
@@ -274,3 +554,385 @@ func (s *TaskService) ProcessTask(task *model.Task) error {
 
 	return nil
 }
+
+// recordDownload stores the outcome of a download in the file; the caller
+// must hold the task lock.
+func (s *TaskService) recordDownload(task *model.Task, file *model.File, res *downloader.Result, err error, finishedAt time.Time) {
+	file.FinishedAt = finishedAt
+	if res != nil {
+		file.Attempts = res.Attempts
+	}
+
+	switch {
+	case err != nil:
+		file.Status = FileStatusFromError(err)
+		file.Error = err.Error()
+	case !ChecksumMatches(file.ExpectedSHA, res.SHA256):
+		s.logger.Error("checksum mismatch",
+			slog.Uint64("task_id", task.ID),
+			slog.String("file_url", file.URL),
+			slog.String("expected", file.ExpectedSHA),
+			slog.String("actual", res.SHA256),
+		)
+		file.Status = model.FileStatusChecksumMismatch
+		file.Size = res.Size
+		file.SHA256 = res.SHA256
+		file.Error = fmt.Sprintf("sha256 mismatch: expected %s, got %s", file.ExpectedSHA, res.SHA256)
+		s.removeDownload(res.Path)
+	default:
+		file.Status = model.FileStatusCompleted
+		file.Name = filepath.Base(res.Path)
+		file.Size = res.Size
+		file.SHA256 = res.SHA256
+		file.ContentType = res.ContentType
+		file.Cached = res.Cached
+		s.logger.Info("file downloading successfully",
+			slog.Uint64("task_id", task.ID),
+			slog.String("file_url", file.URL),
+		)
+	}
+}
+
+// withTaskLock runs fn under the task lock. It waits without a timeout, so
+// it is only for the short updates that must not be lost.
+func (s *TaskService) withTaskLock(id uint64, fn func()) {
+	unlock, _ := s.lockManager.Lock(context.Background(), id)
+	defer unlock()
+
+	fn()
+}
+
+// CancelTask stops a task at any stage before completion and returns its
+// status. A running task is interrupted through its context and marks
+// itself cancelled in ProcessTask once its downloads have stopped, so it is
+// still in_progress when CancelTask returns.
+func (s *TaskService) CancelTask(id uint64) (model.TaskStatus, error) {
+	s.logger.Info("cancelling task",
+		slog.Uint64("id", id),
+	)
+
+	task, err := s.repo.GetByID(id)
+	if err != nil {
+		s.logger.Error("error getting task by id",
+			slog.Uint64("id", id),
+			slog.String("error", err.Error()),
+		)
+		return "", fmt.Errorf("%w by id %d", ErrTaskNotFound, id)
+	}
+
+	s.requestCancel(id)
+	defer s.clearCancelRequest(id)
+
+	unlock, err := s.lockTask(id)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	switch task.Status {
+	case model.TaskStatusCancelled, model.TaskStatusInProgress:
+		return task.Status, nil
+	case model.TaskStatusAccepted, model.TaskStatusQueued:
+		s.taskQueue.Remove(id)
+		task.Status = model.TaskStatusCancelled
+		task.SubmitPending = false
+		task.ExpiresAt = s.expiresAt()
+		s.saveTask(task)
+		s.activeTasks.Add(^uint64(0))
+	default:
+		s.logger.Warn("task can't be cancelled",
+			slog.Uint64("id", id),
+			slog.String("status", string(task.Status)),
+		)
+		return "", fmt.Errorf("%w with status %s", ErrTaskNotCancellable, task.Status)
+	}
+
+	s.logger.Info("task cancelled",
+		slog.Uint64("id", id),
+	)
+
+	return task.Status, nil
+}
+
+// Restore picks up tasks left by a previous run and must be called before
+// the workers start: the id counter continues from the highest id the
+// repository ever saved, so ids of removed tasks aren't handed out again,
+// unfinished tasks take their activeTasks slots again and the ones that
+// were already queued are sent back to the queue in the order they were
+// queued in, so with a file repo the queue survives a restart.
+func (s *TaskService) Restore() error {
+	tasks, err := s.repo.GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to load tasks: %w", err)
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].ID < tasks[j].ID
+	})
+
+	if last := s.repo.LastID(); last > s.idCounter.Load() {
+		s.idCounter.Store(last)
+	}
+
+	requeued := make([]*model.Task, 0)
+	for _, task := range tasks {
+		if task.Status != model.TaskStatusAccepted &&
+			task.Status != model.TaskStatusQueued &&
+			task.Status != model.TaskStatusInProgress {
+			continue
+		}
+
+		s.activeTasks.Add(1)
+
+		// Restore runs before the workers and the server start, so the lock
+		// is free; without a deadline Lock can't fail anyway
+		unlock, _ := s.lockManager.Lock(context.Background(), task.ID)
+		requeue := task.Status != model.TaskStatusAccepted
+		// checks cut short by the restart are run again
+		if task.Status == model.TaskStatusAccepted {
+			for _, file := range task.Files {
+				if file.Status == model.FileStatusValidating {
+					go s.validateFile(task, file)
+				}
+			}
+		}
+		if task.Status == model.TaskStatusInProgress {
+			// the files of the interrupted run are downloaded again
+			s.removeTaskFiles(task)
+			task.Status = model.TaskStatusQueued
+			for _, file := range task.Files {
+				if file.Status == model.FileStatusCompleted ||
+					file.Status == model.FileStatusFailed ||
+					file.Status == model.FileStatusChecksumMismatch {
+					*file = model.File{
+						Status:       model.FileStatusAccepted,
+						URL:          file.URL,
+						ExpectedSize: file.ExpectedSize,
+						ExpectedSHA:  file.ExpectedSHA,
+						ClientName:   file.ClientName,
+					}
+				}
+			}
+			task.Error = ""
+			s.saveTask(task)
+		}
+		unlock()
+
+		if requeue {
+			requeued = append(requeued, task)
+		}
+	}
+
+	sort.SliceStable(requeued, func(i, j int) bool {
+		return requeued[i].QueuedAt.Before(requeued[j].QueuedAt)
+	})
+	for _, task := range requeued {
+		if err := s.taskQueue.Push(task); err != nil {
+			return fmt.Errorf("failed to requeue task %d: %w", task.ID, err)
+		}
+		s.logger.Info("restored task goes to queue",
+			slog.Uint64("id", task.ID),
+		)
+	}
+
+	s.logger.Info("tasks restored",
+		slog.Int("total", len(tasks)),
+		slog.Uint64("active", s.activeTasks.Load()),
+		slog.Uint64("last_id", s.idCounter.Load()),
+	)
+
+	return nil
+}
+
+// nameFiles renames the downloads to their names in the archive. Names are
+// handed out in file order, so duplicates get the same suffixes however
+// the downloads finished.
+func (s *TaskService) nameFiles(task *model.Task, dirPath string, results []*downloader.Result) {
+	taken := map[string]bool{strings.ToLower(archiver.ManifestName): true}
+	if entries, err := os.ReadDir(dirPath); err == nil {
+		for _, e := range entries {
+			taken[strings.ToLower(e.Name())] = true
+		}
+	}
+
+	for i, file := range task.Files {
+		res := results[i]
+		if res == nil || file.Status != model.FileStatusCompleted {
+			continue
+		}
+
+		want := file.ClientName
+		if want == "" {
+			want = res.FileName
+		}
+		name := UniqueFileName(SanitizeFileName(want), taken)
+
+		if err := os.Rename(res.Path, filepath.Join(dirPath, name)); err != nil {
+			s.logger.Warn("failed to rename downloaded file",
+				slog.Uint64("task_id", task.ID),
+				slog.String("path", res.Path),
+				slog.String("name", name),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		file.Name = name
+	}
+}
+
+// removeDownload keeps a rejected file out of the archive.
+func (s *TaskService) removeDownload(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		s.logger.Warn("failed to remove downloaded file",
+			slog.String("path", path),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// archiverFor falls back to zip for tasks stored before formats existed.
+func (s *TaskService) archiverFor(task *model.Task) archiver.Archiver {
+	if arch, ok := s.archivers[task.Format]; ok {
+		return arch
+	}
+	return s.archivers[model.ArchiveFormatZip]
+}
+
+// abortTask must be called with the task lock held.
+func (s *TaskService) abortTask(task *model.Task) {
+	s.removeTaskFiles(task)
+
+	task.Status = model.TaskStatusCancelled
+	task.ExpiresAt = s.expiresAt()
+	s.saveTask(task)
+
+	s.logger.Info("task processing cancelled",
+		slog.Uint64("id", task.ID),
+	)
+}
+
+// errCancelRequested is the cause of a cancel coming from CancelTask, which
+// tells it apart from the shutdown of the workers.
+var errCancelRequested = errors.New("task cancel requested")
+
+// registerCancel exposes a running task to CancelTask. A cancel requested
+// just before the task started is applied right away.
+func (s *TaskService) registerCancel(id uint64, cancel context.CancelCauseFunc) {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+
+	s.cancels[id] = cancel
+	if _, ok := s.cancelRequests[id]; ok {
+		cancel(errCancelRequested)
+	}
+}
+
+func (s *TaskService) unregisterCancel(id uint64) {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+
+	delete(s.cancels, id)
+}
+
+func (s *TaskService) requestCancel(id uint64) {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+
+	s.cancelRequests[id] = struct{}{}
+	if cancel, ok := s.cancels[id]; ok {
+		cancel(errCancelRequested)
+	}
+}
+
+func (s *TaskService) clearCancelRequest(id uint64) {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+
+	delete(s.cancelRequests, id)
+}
+
+// lockTask waits for the task lock at most LockTimeout, so a request never
+// hangs behind a long-running holder.
+func (s *TaskService) lockTask(id uint64) (func(), error) {
+	ctx := context.Background()
+	if s.cfg.LockTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.LockTimeout)
+		defer cancel()
+	}
+
+	unlock, err := s.lockManager.Lock(ctx, id)
+	if err != nil {
+		s.logger.Warn("timed out waiting for task lock",
+			slog.Uint64("id", id),
+			slog.Duration("timeout", s.cfg.LockTimeout),
+		)
+		return nil, fmt.Errorf("%w: %d", ErrTaskBusy, id)
+	}
+
+	return unlock, nil
+}
+
+func (s *TaskService) LockStats() LockStats {
+	return s.lockManager.Stats()
+}
+
+// submit queues the task, or leaves that to the last file check when some
+// files are still validating. A task whose files were all rejected is not
+// queued. It must be called with the task lock held.
+func (s *TaskService) submit(task *model.Task) error {
+	if !HasPendingFiles(task.Files) {
+		return fmt.Errorf("%w that passed validation", ErrTaskNoFiles)
+	}
+
+	if HasValidatingFiles(task.Files) {
+		task.SubmitPending = true
+		s.saveTask(task)
+		s.logger.Info("task waits for file validation",
+			slog.Uint64("id", task.ID),
+		)
+		return nil
+	}
+
+	s.enqueue(task)
+	return nil
+}
+
+// enqueue must be called with the task lock held. The push doesn't block;
+// a task the queue refuses while shutting down stays queued in the
+// repository for Restore.
+func (s *TaskService) enqueue(task *model.Task) {
+	task.Status = model.TaskStatusQueued
+	task.SubmitPending = false
+	task.Error = ""
+	task.QueuedAt = time.Now().UTC()
+	s.saveTask(task)
+
+	if err := s.taskQueue.Push(task); err != nil {
+		s.logger.Warn("failed to push task to queue",
+			slog.Uint64("id", task.ID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	s.logger.Info("task goes to queue",
+		slog.Uint64("id", task.ID),
+		slog.String("priority", string(task.Priority)),
+		slog.Int("files", len(task.Files)),
+	)
+}
+
+// QueuePosition is the number of tasks the workers take before id; ok is
+// false when the task isn't waiting in the queue.
+func (s *TaskService) QueuePosition(id uint64) (int, bool) {
+	return s.taskQueue.Position(id)
+}
+
+func (s *TaskService) saveTask(task *model.Task) {
+	if err := s.repo.Save(task); err != nil {
+		s.logger.Error("error saving task",
+			slog.Uint64("id", task.ID),
+			slog.String("error", err.Error()),
+		)
+	}
+}
@@ -0,0 +1,293 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/folivorra/ziper/internal/adapter/archiver"
+	"github.com/folivorra/ziper/internal/config"
+	"github.com/folivorra/ziper/internal/model"
+	"github.com/folivorra/ziper/internal/repository"
+	"github.com/folivorra/ziper/internal/transport/validation"
+)
+
+// blockingValidator holds every check until release is closed, so a test
+// can look at the task while its files are still validating.
+type blockingValidator struct {
+	release chan struct{}
+	started chan string
+	errs    map[string]error
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func newBlockingValidator(errs map[string]error) *blockingValidator {
+	return &blockingValidator{
+		release: make(chan struct{}),
+		started: make(chan string, 16),
+		errs:    errs,
+		calls:   make(map[string]int),
+	}
+}
+
+func (v *blockingValidator) Validate(url string) (*validation.Info, error) {
+	v.mu.Lock()
+	v.calls[url]++
+	v.mu.Unlock()
+
+	v.started <- url
+	<-v.release
+
+	if err := v.errs[url]; err != nil {
+		return nil, err
+	}
+	return &validation.Info{ContentLength: 100}, nil
+}
+
+func (v *blockingValidator) waitStarted(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-v.started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d of %d checks started", i, n)
+		}
+	}
+}
+
+type stubArchiver struct{}
+
+func (stubArchiver) ArchiveDirectory(context.Context, string, *archiver.Manifest) error {
+	return nil
+}
+
+func (stubArchiver) WriteArchive(context.Context, io.Writer, string, *archiver.Manifest) error {
+	return nil
+}
+
+func (stubArchiver) Extension() string   { return ".zip" }
+func (stubArchiver) ContentType() string { return "application/zip" }
+
+func newTestService(repo repository.TaskRepo, validr validation.FileValidator, queue TaskQueue) *TaskService {
+	cfg := config.Config{
+		MaxTasks:       2,
+		MaxFilesInTask: 3,
+		ArchDir:        "archives",
+		DownloadDir:    "downloads",
+		LockTimeout:    time.Second,
+	}
+	return NewTaskService(repo, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), NewLockTaskManager(),
+		validr, nil, map[model.ArchiveFormat]archiver.Archiver{model.ArchiveFormatZip: stubArchiver{}}, nil, queue)
+}
+
+// waitForTask polls the task until cond holds and returns the last snapshot.
+func waitForTask(t *testing.T, s *TaskService, id uint64, cond func(*model.Task) bool) *model.Task {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		task, err := s.GetTask(id)
+		if err != nil {
+			t.Fatalf("GetTask(%d): %v", id, err)
+		}
+		if cond(task) {
+			return task
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task %d never reached the expected state: %+v", id, task)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCreateTaskSubmitsAfterValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		errs       map[string]error
+		wantStatus model.TaskStatus
+		wantQueued int
+		wantError  bool
+		wantFiles  []model.FileStatus
+	}{
+		{
+			name:       "some files pass",
+			errs:       map[string]error{"http://example.com/b.pdf": fmt.Errorf("%w: 404", validation.ErrNotReachable)},
+			wantStatus: model.TaskStatusQueued,
+			wantQueued: 1,
+			wantFiles:  []model.FileStatus{model.FileStatusAccepted, model.FileStatusNotReachable},
+		},
+		{
+			name: "all files rejected",
+			errs: map[string]error{
+				"http://example.com/a.pdf": fmt.Errorf("%w text/html", validation.ErrNotSupportedType),
+				"http://example.com/b.pdf": fmt.Errorf("%w: 404", validation.ErrNotReachable),
+			},
+			wantStatus: model.TaskStatusAccepted,
+			wantError:  true,
+			wantFiles:  []model.FileStatus{model.FileStatusNotSupportedType, model.FileStatusNotReachable},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validr := newBlockingValidator(tt.errs)
+			queue := NewPriorityQueue()
+			s := newTestService(repository.NewInMemoryTaskRepo(), validr, queue)
+
+			created, err := s.CreateTask(TaskOptions{
+				Files: []FileRequest{
+					{URL: "http://example.com/a.pdf"},
+					{URL: "http://example.com/b.pdf"},
+				},
+				Submit: true,
+			})
+			if err != nil {
+				t.Fatalf("CreateTask: %v", err)
+			}
+			validr.waitStarted(t, 2)
+
+			// the submit waits for the checks instead of queueing the task
+			if created.Status != model.TaskStatusAccepted || !created.SubmitPending {
+				t.Fatalf("created = %+v, want accepted and submit pending", created)
+			}
+			task, err := s.GetTask(created.ID)
+			if err != nil {
+				t.Fatalf("GetTask: %v", err)
+			}
+			for i, f := range task.Files {
+				if f.Status != model.FileStatusValidating {
+					t.Errorf("file %d is %s before the check finished", i, f.Status)
+				}
+			}
+			if queue.Len() != 0 {
+				t.Fatalf("task queued before its files were checked")
+			}
+
+			close(validr.release)
+			task = waitForTask(t, s, created.ID, func(task *model.Task) bool {
+				return !task.SubmitPending
+			})
+
+			if task.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", task.Status, tt.wantStatus)
+			}
+			if queue.Len() != tt.wantQueued {
+				t.Errorf("queue holds %d tasks, want %d", queue.Len(), tt.wantQueued)
+			}
+			if (task.Error != "") != tt.wantError {
+				t.Errorf("error = %q, want error %v", task.Error, tt.wantError)
+			}
+			for i, want := range tt.wantFiles {
+				if got := task.Files[i].Status; got != want {
+					t.Errorf("file %d status = %s, want %s", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateTaskRejectsWithoutTakingSlot(t *testing.T) {
+	validr := newBlockingValidator(nil)
+	close(validr.release)
+	s := newTestService(repository.NewInMemoryTaskRepo(), validr, NewPriorityQueue())
+
+	created, err := s.CreateTask(TaskOptions{
+		Files: []FileRequest{
+			{URL: "http://example.com/a.pdf", SHA256: "not-a-sum"},
+			{URL: "not a url"},
+		},
+		Submit: true,
+	})
+	if !errors.Is(err, ErrNoFilesAccepted) {
+		t.Fatalf("CreateTask error = %v, want %v", err, ErrNoFilesAccepted)
+	}
+	if len(created.Files) != 2 ||
+		created.Files[0].Status != model.FileStatusFailed ||
+		created.Files[1].Status != model.FileStatusInvalidURL {
+		t.Errorf("file results = %+v", created.Files)
+	}
+
+	// MaxTasks is 2: both still fit, and the rejected request used no id
+	for i := uint64(1); i <= 2; i++ {
+		created, err := s.CreateTask(TaskOptions{})
+		if err != nil {
+			t.Fatalf("CreateTask after a rejected one: %v", err)
+		}
+		if created.ID != i {
+			t.Errorf("id = %d, want %d", created.ID, i)
+		}
+	}
+}
+
+func TestRestoreRevalidatesFiles(t *testing.T) {
+	repo := repository.NewInMemoryTaskRepo()
+	tasks := []*model.Task{
+		{
+			ID:            1,
+			Status:        model.TaskStatusAccepted,
+			SubmitPending: true,
+			Files: []*model.File{
+				{Status: model.FileStatusValidating, URL: "http://example.com/a.pdf", ExpectedSize: -1},
+				{Status: model.FileStatusAccepted, URL: "http://example.com/b.pdf", ExpectedSize: 10},
+			},
+		},
+		{
+			ID:     2,
+			Status: model.TaskStatusAccepted,
+			Files: []*model.File{
+				{Status: model.FileStatusValidating, URL: "http://example.com/c.pdf", ExpectedSize: -1},
+			},
+		},
+		{ID: 3, Status: model.TaskStatusCompleted},
+	}
+	for _, task := range tasks {
+		if err := repo.Save(task); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+
+	validr := newBlockingValidator(nil)
+	queue := NewPriorityQueue()
+	s := newTestService(repo, validr, queue)
+	if err := s.Restore(); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	// only the files left validating are checked again
+	validr.waitStarted(t, 2)
+	if queue.Len() != 0 {
+		t.Fatalf("task queued before its files were checked")
+	}
+	close(validr.release)
+
+	submitted := waitForTask(t, s, 1, func(task *model.Task) bool {
+		return task.Status != model.TaskStatusAccepted
+	})
+	if submitted.Status != model.TaskStatusQueued || queue.Len() != 1 {
+		t.Errorf("submitted task = %s with %d queued, want queued", submitted.Status, queue.Len())
+	}
+
+	kept := waitForTask(t, s, 2, func(task *model.Task) bool {
+		return task.Files[0].Status != model.FileStatusValidating
+	})
+	if kept.Status != model.TaskStatusAccepted || kept.Files[0].Status != model.FileStatusAccepted {
+		t.Errorf("unsubmitted task = %s with file %s, want accepted", kept.Status, kept.Files[0].Status)
+	}
+
+	validr.mu.Lock()
+	defer validr.mu.Unlock()
+	if validr.calls["http://example.com/b.pdf"] != 0 {
+		t.Error("an accepted file was checked again")
+	}
+
+	// the restored tasks hold their slots, MaxTasks is 2
+	if _, err := s.CreateTask(TaskOptions{}); err == nil {
+		t.Error("CreateTask succeeded with the restored tasks holding every slot")
+	}
+}
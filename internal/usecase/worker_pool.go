@@ -2,6 +2,7 @@ package usecase
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"sync"
 
@@ -11,8 +12,9 @@ import (
 
 type WorkerPool struct {
 	ctx        context.Context
+	cancel     context.CancelFunc
 	app        *app.App
-	tasks      chan *model.Task
+	tasks      TaskQueue
 	workersNum int
 	service    *TaskService
 	wg         *sync.WaitGroup
@@ -25,10 +27,12 @@ func NewWorkerPool(
 	workersNum int,
 	service *TaskService,
 	logger *slog.Logger,
-	tasks chan *model.Task,
+	tasks TaskQueue,
 ) *WorkerPool {
+	ctx, cancel := context.WithCancel(ctx)
 	wp := &WorkerPool{
 		ctx:        ctx,
+		cancel:     cancel,
 		app:        app,
 		tasks:      tasks,
 		workersNum: workersNum,
@@ -51,43 +55,48 @@ func (wp *WorkerPool) Start() {
 		go func(workerID int) {
 			defer wp.wg.Done()
 			for {
-				select {
-				case <-wp.ctx.Done():
-					return
-				case task, ok := <-wp.tasks:
-					if !ok {
-						return
+				task, err := wp.tasks.Pop(wp.ctx)
+				if err != nil {
+					if !errors.Is(err, ErrQueueClosed) && wp.ctx.Err() == nil {
+						wp.logger.Error("failed to take task from queue",
+							slog.Int("worker_id", workerID),
+							slog.String("error", err.Error()),
+						)
 					}
+					return
+				}
 
-					func(task *model.Task) {
-						defer func() {
-							if r := recover(); r != nil {
-								wp.logger.Error("worker panicked",
-									slog.Int("worker_id", workerID),
-									slog.Any("error", r),
-								)
-							}
-						}()
+				func(task *model.Task) {
+					defer func() {
+						if r := recover(); r != nil {
+							wp.logger.Error("worker panicked",
+								slog.Int("worker_id", workerID),
+								slog.Any("error", r),
+							)
+						}
+					}()
 
-						wp.logger.Info("worker started processing task",
+					wp.logger.Info("worker started processing task",
+						slog.Int("worker_id", workerID),
+						slog.Uint64("task_id", task.ID),
+					)
+					if err := wp.service.ProcessTask(wp.ctx, task); err != nil {
+						wp.logger.Error("error processing task",
 							slog.Int("worker_id", workerID),
 							slog.Uint64("task_id", task.ID),
+							slog.String("error", err.Error()),
 						)
-						if err := wp.service.ProcessTask(task); err != nil {
-							wp.logger.Error("error processing task",
-								slog.Int("worker_id", workerID),
-								slog.Uint64("task_id", task.ID),
-								slog.String("error", err.Error()),
-							)
-						}
-					}(task)
-				}
+					}
+				}(task)
 			}
 		}(i)
 	}
 }
 
+// Stop interrupts the running tasks, which stay in_progress for Restore,
+// and waits for the workers to return.
 func (wp *WorkerPool) Stop() {
-	close(wp.tasks)
+	wp.tasks.Close()
+	wp.cancel()
 	wp.wg.Wait()
 }